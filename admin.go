@@ -0,0 +1,221 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// AdminSecretHeader is the request header that must carry the shared
+// secret of the admin endpoint.
+const AdminSecretHeader = "X-Stencil-Secret"
+
+// adminEntry describes a cache entry in admin responses.
+type adminEntry struct {
+	Host     string    `json:"host"`
+	URI      string    `json:"uri"`
+	Template string    `json:"template"`
+	Stored   time.Time `json:"stored"`
+	Expires  time.Time `json:"expires"`
+	Size     int       `json:"size"`
+}
+
+// adminStats is the state of the middleware in admin responses.
+type adminStats struct {
+	Renders      []limiterStats `json:"renders"`
+	CacheEntries int            `json:"cache_entries"`
+}
+
+// warmResult is the outcome of a warm-up render in admin responses.
+type warmResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status"`
+	Cached bool   `json:"cached"`
+	Error  string `json:"error,omitempty"`
+}
+
+// adminConfig returns the configuration whose admin endpoint r is for,
+// or nil if r is not an admin request.
+func (st Stencil) adminConfig(r *http.Request) *Config {
+	for _, c := range st.Configs {
+		if c.AdminPath != "" && httpserver.Path(r.URL.Path).Matches(c.AdminPath) {
+			return c
+		}
+	}
+	return nil
+}
+
+// serveAdmin handles the admin endpoint of cfg. It reports statistics and
+// lists, purges and warms up the render cache.
+func (st Stencil) serveAdmin(w http.ResponseWriter, r *http.Request, cfg *Config) (int, error) {
+	secret := r.Header.Get(AdminSecretHeader)
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.AdminSecret)) != 1 {
+		return http.StatusForbidden, nil
+	}
+
+	var result interface{}
+	switch strings.Trim(strings.TrimPrefix(r.URL.Path, cfg.AdminPath), "/") {
+	case "entries":
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		result = st.adminEntries()
+	case "stats":
+		if r.Method != http.MethodGet {
+			return http.StatusMethodNotAllowed, nil
+		}
+		result = st.adminStats()
+	case "purge":
+		if r.Method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		n, ok := st.adminPurge(r)
+		if !ok {
+			return http.StatusBadRequest, nil
+		}
+		result = map[string]int{"purged": n}
+	case "warm":
+		if r.Method != http.MethodPost {
+			return http.StatusMethodNotAllowed, nil
+		}
+		var urls []string
+		if err := json.NewDecoder(r.Body).Decode(&urls); err != nil {
+			return http.StatusBadRequest, err
+		}
+		result = st.adminWarm(r, urls)
+	default:
+		return http.StatusNotFound, nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		return http.StatusInternalServerError, err
+	}
+	return 0, nil
+}
+
+func (st Stencil) adminEntries() []adminEntry {
+	entries := []adminEntry{}
+	if st.cache == nil {
+		return entries
+	}
+	for _, e := range st.cache.list() {
+		entries = append(entries, adminEntry{
+			Host:     e.host,
+			URI:      e.uri,
+			Template: e.page.template,
+			Stored:   e.stored,
+			Expires:  e.expires,
+			Size:     len(e.page.body),
+		})
+	}
+	return entries
+}
+
+// adminStats reports the state of the render limiters and the cache.
+func (st Stencil) adminStats() adminStats {
+	stats := adminStats{Renders: []limiterStats{}}
+	for _, c := range st.Configs {
+		if c.limiter != nil {
+			renders := c.limiter.stats()
+			renders.Path = c.PathScope
+			stats.Renders = append(stats.Renders, renders)
+		}
+	}
+	if st.cache != nil {
+		stats.CacheEntries = st.cache.len()
+	}
+	return stats
+}
+
+// adminPurge removes the cache entries matching the url, prefix or
+// template query parameters of r. It returns false if none was given.
+func (st Stencil) adminPurge(r *http.Request) (int, bool) {
+	q := r.URL.Query()
+	uri, prefix, template := q.Get("url"), q.Get("prefix"), q.Get("template")
+	_, hasTemplate := q["template"]
+	if uri == "" && prefix == "" && !hasTemplate {
+		return 0, false
+	}
+	if st.cache == nil {
+		return 0, true
+	}
+
+	return st.cache.purge(func(e *cacheEntry) bool {
+		switch {
+		case uri != "":
+			return e.uri == uri
+		case prefix != "":
+			return strings.HasPrefix(e.uri, prefix)
+		default:
+			return e.page.template == template
+		}
+	}), true
+}
+
+// adminWarm renders each of urls on the host of r and caches the results.
+func (st Stencil) adminWarm(r *http.Request, urls []string) []warmResult {
+	results := make([]warmResult, 0, len(urls))
+	for _, u := range urls {
+		results = append(results, st.warm(r, u))
+	}
+	return results
+}
+
+// warm renders u on the host of r and caches the result.
+func (st Stencil) warm(r *http.Request, u string) warmResult {
+	res := warmResult{URL: u}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	req.Host = r.Host
+	req = req.WithContext(context.WithValue(detachedContext{r.Context()}, httpserver.OriginalURLCtxKey, *req.URL))
+
+	cfg := st.config(req)
+	if cfg == nil {
+		res.Error = "not handled by stencil"
+		return res
+	}
+
+	p, code, err := st.render(newDiscardResponseWriter(), req, cfg, false)
+	res.Status = code
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if p == nil {
+		return res
+	}
+
+	res.Status = http.StatusOK
+	if p.status != 0 {
+		res.Status = p.status
+	}
+	if st.cache != nil && cfg.CacheTTL > 0 {
+		res.Cached = st.cache.put(req, cfg, p)
+	}
+	return res
+}
@@ -0,0 +1,85 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdmin(t *testing.T) {
+	cfg := &Config{
+		PathScope:   "/",
+		CacheTTL:    time.Minute,
+		AdminPath:   "/_stencil",
+		AdminSecret: "s3cret",
+	}
+	st := Stencil{
+		Configs: []*Config{cfg},
+		cache:   newRenderCache(10),
+	}
+	for _, path := range []string{"/blog/a.json", "/blog/b.json", "/about.json"} {
+		r, _ := http.NewRequest("GET", path, nil)
+		st.cache.put(r, cfg, &page{header: make(http.Header), template: "post"})
+	}
+
+	admin := func(method, path, secret string) (*httptest.ResponseRecorder, int) {
+		r, _ := http.NewRequest(method, path, nil)
+		r.Header.Set(AdminSecretHeader, secret)
+		rec := httptest.NewRecorder()
+		code, err := st.ServeHTTP(rec, r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rec, code
+	}
+
+	if _, code := admin("GET", "/_stencil/entries", "wrong"); code != http.StatusForbidden {
+		t.Errorf("Expected status %d without the secret, got %d", http.StatusForbidden, code)
+	}
+
+	rec, _ := admin("GET", "/_stencil/entries", "s3cret")
+	var entries []adminEntry
+	if err := json.NewDecoder(rec.Body).Decode(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 entries, got %d", len(entries))
+	}
+
+	rec, _ = admin("POST", "/_stencil/purge?prefix=/blog/", "s3cret")
+	var purged map[string]int
+	if err := json.NewDecoder(rec.Body).Decode(&purged); err != nil {
+		t.Fatal(err)
+	}
+	if purged["purged"] != 2 {
+		t.Errorf("Expected 2 entries purged by prefix, got %d", purged["purged"])
+	}
+
+	rec, _ = admin("POST", "/_stencil/purge?template=post", "s3cret")
+	if err := json.NewDecoder(rec.Body).Decode(&purged); err != nil {
+		t.Fatal(err)
+	}
+	if purged["purged"] != 1 {
+		t.Errorf("Expected 1 entry purged by template, got %d", purged["purged"])
+	}
+
+	if _, code := admin("POST", "/_stencil/purge", "s3cret"); code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for purge without a filter, got %d", http.StatusBadRequest, code)
+	}
+}
@@ -0,0 +1,382 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultCacheSize is the number of pages cached if no size is configured.
+const defaultCacheSize = 1000
+
+// renderCache is an in-memory LRU cache of rendered pages.
+type renderCache struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+
+	// request headers that select a variant, by primary key
+	vary map[string][]string
+}
+
+// cacheEntry is a cached page and the times it may be served until.
+type cacheEntry struct {
+	key     string
+	host    string
+	uri     string
+	page    *page
+	stored  time.Time
+	expires time.Time
+
+	staleWhileRevalidateUntil time.Time
+	staleIfErrorUntil         time.Time
+
+	revalidating bool
+}
+
+func newRenderCache(size int) *renderCache {
+	return &renderCache{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+		vary:    make(map[string][]string),
+	}
+}
+
+// fresh reports whether the entry may be served as is.
+func (e *cacheEntry) fresh(now time.Time) bool {
+	return now.Before(e.expires)
+}
+
+// staleWhileRevalidate reports whether the stale entry may be served
+// while it is refreshed in the background.
+func (e *cacheEntry) staleWhileRevalidate(now time.Time) bool {
+	return now.Before(e.staleWhileRevalidateUntil)
+}
+
+// staleIfError reports whether the stale entry may be served when the
+// upstream fails.
+func (e *cacheEntry) staleIfError(now time.Time) bool {
+	return now.Before(e.staleIfErrorUntil)
+}
+
+// get returns the cached entry for r, or nil if there is none.
+func (c *renderCache) get(r *http.Request, cfg *Config) *cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	primary := primaryCacheKey(r, cfg)
+	if !cookiesVaried(r, c.vary[primary]) {
+		return nil
+	}
+	el, ok := c.entries[variantCacheKey(primary, c.vary[primary], r)]
+	if !ok {
+		return nil
+	}
+	c.lru.MoveToFront(el)
+	return el.Value.(*cacheEntry)
+}
+
+// cacheableStatus reports whether a page with the status code may be
+// cached. Zero means the page is served with 200. Errors other than the
+// ones that don't depend on the client, such as 404 Not Found, are
+// never cached.
+func cacheableStatus(code int) bool {
+	switch code {
+	case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong:
+		return true
+	}
+	return code < 400
+}
+
+// put stores p as the page for r, unless the page says it must not be
+// stored or has a status that isn't cacheable. It reports whether p was
+// stored. Cache-Control directives of the page take precedence over cfg.
+func (c *renderCache) put(r *http.Request, cfg *Config, p *page) bool {
+	if !cacheableStatus(p.status) || !sharedPage(p) {
+		return false
+	}
+	cc := parseCacheControl(p.header.Get("Cache-Control"))
+
+	ttl := cfg.CacheTTL
+	if age, ok := ccSeconds(cc, "s-maxage"); ok {
+		ttl = age
+	} else if age, ok := ccSeconds(cc, "max-age"); ok {
+		ttl = age
+	}
+	swr := cfg.StaleWhileRevalidate
+	if d, ok := ccSeconds(cc, "stale-while-revalidate"); ok {
+		swr = d
+	}
+	sie := cfg.StaleIfError
+	if d, ok := ccSeconds(cc, "stale-if-error"); ok {
+		sie = d
+	}
+	if ttl <= 0 && swr <= 0 && sie <= 0 {
+		return false
+	}
+
+	var varyNames []string
+	for _, v := range p.header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return false
+			}
+			if name != "" {
+				varyNames = append(varyNames, name)
+			}
+		}
+	}
+	sort.Strings(varyNames)
+	if !cookiesVaried(r, varyNames) {
+		return false
+	}
+
+	now := time.Now()
+	primary := primaryCacheKey(r, cfg)
+	e := &cacheEntry{
+		key:                       variantCacheKey(primary, varyNames, r),
+		host:                      r.Host,
+		uri:                       r.URL.RequestURI(),
+		page:                      p,
+		stored:                    now,
+		expires:                   now.Add(ttl),
+		staleWhileRevalidateUntil: now.Add(ttl + swr),
+		staleIfErrorUntil:         now.Add(ttl + sie),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.vary[primary] = varyNames
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return true
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		c.removeElement(c.lru.Back())
+	}
+	return true
+}
+
+// startRevalidation marks e as being refreshed. It returns false if a
+// refresh is already under way.
+func (c *renderCache) startRevalidation(e *cacheEntry) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e.revalidating {
+		return false
+	}
+	e.revalidating = true
+	return true
+}
+
+// endRevalidation marks e as no longer being refreshed.
+func (c *renderCache) endRevalidation(e *cacheEntry) {
+	c.mu.Lock()
+	e.revalidating = false
+	c.mu.Unlock()
+}
+
+// len returns the number of cached entries.
+func (c *renderCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// list returns the cached entries, most recently used first.
+func (c *renderCache) list() []*cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entries := make([]*cacheEntry, 0, c.lru.Len())
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		entries = append(entries, el.Value.(*cacheEntry))
+	}
+	return entries
+}
+
+// purge removes the entries for which match returns true and returns
+// how many were removed.
+func (c *renderCache) purge(match func(*cacheEntry) bool) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var n int
+	for el := c.lru.Front(); el != nil; {
+		next := el.Next()
+		if match(el.Value.(*cacheEntry)) {
+			c.removeElement(el)
+			n++
+		}
+		el = next
+	}
+	return n
+}
+
+func (c *renderCache) removeElement(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.entries, el.Value.(*cacheEntry).key)
+}
+
+// revalidate renders r again in the background and replaces the stale
+// cache entry e with the result.
+func (st Stencil) revalidate(r *http.Request, cfg *Config, e *cacheEntry) {
+	defer st.cache.endRevalidation(e)
+
+	p, _, _ := st.render(newDiscardResponseWriter(), r, cfg, false)
+	if p != nil {
+		st.cache.put(r, cfg, p)
+	}
+}
+
+// cacheableRequest reports whether the response to r may be cached.
+func cacheableRequest(r *http.Request) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	return r.Header.Get("Authorization") == ""
+}
+
+// sharedPage reports whether p may be stored and served to other
+// clients. Pages that set cookies or are marked no-store, no-cache or
+// private are meant for a single client.
+func sharedPage(p *page) bool {
+	if _, ok := p.header["Set-Cookie"]; ok {
+		return false
+	}
+	cc := parseCacheControl(p.header.Get("Cache-Control"))
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := cc[d]; ok {
+			return false
+		}
+	}
+	return true
+}
+
+// cookiesVaried reports whether a page for r that varies on varyNames
+// can only be served to requests with the same cookies as r. Templates
+// can read cookies, so pages rendered for requests with cookies are not
+// shared unless they vary on them.
+func cookiesVaried(r *http.Request, varyNames []string) bool {
+	if _, ok := r.Header["Cookie"]; !ok {
+		return true
+	}
+	for _, name := range varyNames {
+		if name == "Cookie" {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryCacheKey identifies a page regardless of its variants. HEAD
+// requests share the pages rendered for GET.
+func primaryCacheKey(r *http.Request, cfg *Config) string {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	return method + " " + r.Host + r.URL.Path + "?" + r.URL.RawQuery + " " + cfg.PathScope
+}
+
+// variantCacheKey adds the values of the request headers that a page
+// varies on to its primary key.
+func variantCacheKey(primary string, varyNames []string, r *http.Request) string {
+	key := primary
+	for _, name := range varyNames {
+		key += "\x00" + name + "=" + strings.Join(r.Header[name], ",")
+	}
+	return key
+}
+
+// parseCacheControl splits a Cache-Control header into its directives.
+func parseCacheControl(v string) map[string]string {
+	cc := make(map[string]string)
+	for _, d := range strings.Split(v, ",") {
+		d = strings.TrimSpace(d)
+		if d == "" {
+			continue
+		}
+		name, value := d, ""
+		if i := strings.Index(d, "="); i >= 0 {
+			name, value = d[:i], strings.Trim(d[i+1:], `"`)
+		}
+		cc[strings.ToLower(name)] = value
+	}
+	return cc
+}
+
+// ccSeconds returns the value of a Cache-Control directive in seconds.
+func ccSeconds(cc map[string]string, name string) (time.Duration, bool) {
+	v, ok := cc[name]
+	if !ok {
+		return 0, false
+	}
+	secs, err := strconv.Atoi(v)
+	if err != nil || secs < 0 {
+		return 0, false
+	}
+	return time.Duration(secs) * time.Second, true
+}
+
+// backgroundRequest returns a copy of r that can still be used after r
+// has been handled.
+func backgroundRequest(r *http.Request) *http.Request {
+	br := r.WithContext(detachedContext{r.Context()})
+	br.Header = make(http.Header, len(r.Header))
+	for k, v := range r.Header {
+		br.Header[k] = v
+	}
+	u := *r.URL
+	br.URL = &u
+	return br
+}
+
+// detachedContext keeps the values of a request context but not its
+// cancellation, so that background work can outlive the request.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+// discardResponseWriter is an http.ResponseWriter that throws away
+// everything written to it.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: make(http.Header)}
+}
+
+func (d *discardResponseWriter) Header() http.Header         { return d.header }
+func (d *discardResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponseWriter) WriteHeader(int)             {}
@@ -0,0 +1,140 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestRenderCache(t *testing.T) {
+	cfg := &Config{PathScope: "/", CacheTTL: time.Minute}
+	c := newRenderCache(2)
+
+	req := func(path string) *http.Request {
+		r, err := http.NewRequest("GET", path, nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		return r
+	}
+	pg := func(cacheControl string) *page {
+		p := &page{header: make(http.Header)}
+		if cacheControl != "" {
+			p.header.Set("Cache-Control", cacheControl)
+		}
+		return p
+	}
+
+	c.put(req("/a"), cfg, pg(""))
+	c.put(req("/b"), cfg, pg(""))
+	if c.get(req("/a"), cfg) == nil {
+		t.Fatal("Expected /a to be cached")
+	}
+
+	// /b is now the least recently used page
+	c.put(req("/c"), cfg, pg(""))
+	if c.get(req("/b"), cfg) != nil {
+		t.Error("Expected /b to be evicted")
+	}
+	if c.get(req("/a"), cfg) == nil || c.get(req("/c"), cfg) == nil {
+		t.Error("Expected /a and /c to be cached")
+	}
+
+	// HEAD requests share pages with GET
+	head := req("/a")
+	head.Method = http.MethodHead
+	if c.get(head, cfg) == nil {
+		t.Error("Expected HEAD /a to use the cached GET page")
+	}
+
+	// upstream Cache-Control is honored
+	c.put(req("/private"), cfg, pg("private"))
+	if c.get(req("/private"), cfg) != nil {
+		t.Error("Expected private page not to be cached")
+	}
+	// errors set by the document or template are not cached
+	for _, code := range []int{http.StatusInternalServerError, http.StatusServiceUnavailable, http.StatusForbidden} {
+		p := pg("")
+		p.status = code
+		if c.put(req("/error"), cfg, p) {
+			t.Errorf("Expected page with status %d not to be cached", code)
+		}
+	}
+	gone := pg("")
+	gone.status = http.StatusGone
+	if !c.put(req("/gone"), cfg, gone) {
+		t.Error("Expected page with status 410 to be cached")
+	}
+
+	c.put(req("/short"), cfg, pg("max-age=0, stale-if-error=60"))
+	e := c.get(req("/short"), cfg)
+	if e == nil {
+		t.Fatal("Expected /short to be cached")
+	}
+	if e.fresh(time.Now()) {
+		t.Error("Expected /short to be stale because of max-age=0")
+	}
+	if !e.staleIfError(time.Now()) {
+		t.Error("Expected /short to be usable on error because of stale-if-error")
+	}
+}
+
+func TestRenderCacheVary(t *testing.T) {
+	cfg := &Config{PathScope: "/", CacheTTL: time.Minute}
+	c := newRenderCache(10)
+
+	p := &page{header: http.Header{"Vary": {"Accept-Language"}}}
+	en, _ := http.NewRequest("GET", "/a", nil)
+	en.Header.Set("Accept-Language", "en")
+	c.put(en, cfg, p)
+
+	fr, _ := http.NewRequest("GET", "/a", nil)
+	fr.Header.Set("Accept-Language", "fr")
+	if c.get(fr, cfg) != nil {
+		t.Error("Expected no cached page for another language")
+	}
+	if c.get(en, cfg) == nil {
+		t.Error("Expected cached page for the same language")
+	}
+}
+
+func TestRenderCacheCookies(t *testing.T) {
+	cfg := &Config{PathScope: "/", CacheTTL: time.Minute}
+	c := newRenderCache(10)
+
+	alice, _ := http.NewRequest("GET", "/a", nil)
+	alice.Header.Set("Cookie", "session=alice")
+	if c.put(alice, cfg, &page{header: make(http.Header)}) {
+		t.Error("Expected page rendered with cookies not to be cached")
+	}
+
+	anon, _ := http.NewRequest("GET", "/a", nil)
+	c.put(anon, cfg, &page{header: make(http.Header)})
+	if c.get(alice, cfg) != nil {
+		t.Error("Expected request with cookies not to get the shared page")
+	}
+
+	c.put(alice, cfg, &page{header: http.Header{"Vary": {"Cookie"}}})
+	if c.get(alice, cfg) == nil {
+		t.Error("Expected page that varies on cookies to be cached")
+	}
+	bob, _ := http.NewRequest("GET", "/a", nil)
+	bob.Header.Set("Cookie", "session=bob")
+	if c.get(bob, cfg) != nil {
+		t.Error("Expected no cached page for other cookies")
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// utf8BOM is the byte order mark that some editors put at the start of
+// UTF-8 files.
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// toUTF8 transcodes body to UTF-8. The character set is override, if
+// given, or else detected from a byte order mark, the charset parameter
+// of contentType or a <meta charset> near the start of body. Bodies that
+// don't say and are not valid UTF-8 are taken to be Windows-1252, like
+// browsers do.
+func toUTF8(body []byte, contentType, override string) ([]byte, error) {
+	var e encoding.Encoding
+	var name string
+	if override != "" {
+		if e, name = charset.Lookup(override); e == nil {
+			return nil, fmt.Errorf("stencil: unknown character set %q", override)
+		}
+	} else {
+		var certain bool
+		e, name, certain = charset.DetermineEncoding(body, contentType)
+
+		// the guess only looks at the start of body
+		if !certain && utf8.Valid(body) {
+			name = "utf-8"
+		}
+	}
+
+	if name != "utf-8" {
+		decoded, err := e.NewDecoder().Bytes(body)
+		if err != nil {
+			return nil, err
+		}
+		body = decoded
+	}
+	return bytes.TrimPrefix(body, utf8BOM), nil
+}
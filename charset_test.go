@@ -0,0 +1,54 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		body        string
+		contentType string
+		override    string
+		expected    string
+	}{
+		{"caf\xc3\xa9", "text/html", "", "café"},
+		{"\xef\xbb\xbfcaf\xc3\xa9", "text/html", "", "café"},
+		{"caf\xe9", "text/html; charset=ISO-8859-1", "", "café"},
+		{`<meta charset="iso-8859-1">caf` + "\xe9", "text/html", "", `<meta charset="iso-8859-1">café`},
+		{"\x93quoted\x94", "text/plain", "", "“quoted”"},
+		{"\xff\xfec\x00a\x00f\x00\xe9\x00", "", "", "café"},
+		{"caf\xe9", "text/html; charset=utf-8", "latin1", "café"},
+		// a UTF-8 character beyond what detection looks at
+		{strings.Repeat(" ", 2048) + "caf\xc3\xa9", "text/html", "", strings.Repeat(" ", 2048) + "café"},
+	}
+
+	for i, test := range tests {
+		got, err := toUTF8([]byte(test.body), test.contentType, test.override)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if string(got) != test.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, test.expected, got)
+		}
+	}
+
+	if _, err := toUTF8([]byte("hello"), "", "klingon"); err == nil {
+		t.Error("Expected unknown character set to fail")
+	}
+}
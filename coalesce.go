@@ -0,0 +1,119 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// flightGroup lets concurrent identical requests share a single upstream
+// fetch and render.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flightCall
+}
+
+// flightCall is a render that is in progress or has completed.
+type flightCall struct {
+	wg   sync.WaitGroup
+	page *page
+	code int
+	err  error
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+// do calls fn once for all concurrent callers with the same key and
+// returns its results to each of them. shared is true for the callers
+// that waited on another caller's call.
+func (g *flightGroup) do(key string, fn func() (*page, int, error)) (p *page, code int, shared bool, err error) {
+	g.mu.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		call.wg.Wait()
+		return call.page, call.code, true, call.err
+	}
+	call := new(flightCall)
+	call.wg.Add(1)
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	call.page, call.code, call.err = fn()
+
+	g.mu.Lock()
+	delete(g.calls, key)
+	g.mu.Unlock()
+	call.wg.Done()
+
+	return call.page, call.code, false, call.err
+}
+
+// coalesceKey identifies the requests that may share a render. It is made
+// of the primary cache key and the values of the configured headers.
+func coalesceKey(r *http.Request, cfg *Config) string {
+	key := primaryCacheKey(r, cfg)
+	for _, name := range cfg.CoalesceHeaders {
+		key += "\x00" + name + "=" + strings.Join(r.Header[name], ",")
+	}
+	return key
+}
+
+// coalescable reports whether r may share a render with other requests.
+// Pages may depend on the cookies of the visitor, so requests with
+// cookies are only shared if the cookies are part of the key.
+func coalescable(r *http.Request, cfg *Config) bool {
+	if !cacheableRequest(r) {
+		return false
+	}
+	if _, ok := r.Header["Cookie"]; !ok {
+		return true
+	}
+	for _, name := range cfg.CoalesceHeaders {
+		if name == "Cookie" {
+			return true
+		}
+	}
+	return false
+}
+
+// renderShared renders r, sharing the work with concurrent identical
+// requests if cfg allows it.
+func (st Stencil) renderShared(w http.ResponseWriter, r *http.Request, cfg *Config, captureErrors bool) (*page, int, error) {
+	if st.flights == nil || !cfg.Coalesce || !coalescable(r, cfg) {
+		return st.render(w, r, cfg, captureErrors)
+	}
+
+	p, code, shared, err := st.flights.do(coalesceKey(r, cfg), func() (*page, int, error) {
+		return st.render(w, r, cfg, captureErrors)
+	})
+	if !shared || err != nil || code >= 400 {
+		return p, code, err
+	}
+	if p != nil {
+		// cookies set for the other caller must not be handed out to us
+		if _, ok := p.header["Set-Cookie"]; !ok {
+			return p, code, err
+		}
+	}
+
+	// The response was not a stencil document, so it was written to the
+	// other caller's ResponseWriter, or it was meant for the other caller
+	// only. Fetch our own copy.
+	return st.render(w, r, cfg, captureErrors)
+}
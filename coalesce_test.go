@@ -0,0 +1,169 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestCoalesce(t *testing.T) {
+	var hits int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		Coalesce:      true,
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			close(entered)
+		}
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "Coalesced"}`))
+		return http.StatusOK, nil
+	})
+	st.flights = newFlightGroup()
+
+	const requests = 10
+	var wg, started sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, requests)
+	for i := range recs {
+		recs[i] = httptest.NewRecorder()
+		wg.Add(1)
+		started.Add(1)
+		go func(rec *httptest.ResponseRecorder) {
+			defer wg.Done()
+			started.Done()
+			req, err := http.NewRequest("GET", "/page.json", nil)
+			if err != nil {
+				t.Errorf("Could not create HTTP request: %v", err)
+				return
+			}
+			if _, err := st.ServeHTTP(rec, req); err != nil {
+				t.Error(err)
+			}
+		}(recs[i])
+	}
+
+	// hold the first render until every request is on its way; requests
+	// that arrive after it finished render on their own
+	<-entered
+	started.Wait()
+	close(release)
+	wg.Wait()
+
+	if hits >= requests {
+		t.Errorf("Expected requests to share renders, got %d upstream hits for %d requests", hits, requests)
+	}
+	for i, rec := range recs {
+		if !strings.Contains(rec.Body.String(), "<title>Coalesced</title>") {
+			t.Errorf("Request %d: expected rendered page, got %q", i, rec.Body.String())
+		}
+	}
+}
+
+func TestCoalescable(t *testing.T) {
+	tests := []struct {
+		method  string
+		header  http.Header
+		headers []string
+		want    bool
+	}{
+		{"GET", nil, nil, true},
+		{"POST", nil, nil, false},
+		{"GET", http.Header{"Authorization": {"Bearer x"}}, nil, false},
+		{"GET", http.Header{"Cookie": {"session=a"}}, nil, false},
+		{"GET", http.Header{"Cookie": {"session=a"}}, []string{"Cookie"}, true},
+	}
+
+	for i, test := range tests {
+		r := httptest.NewRequest(test.method, "/page.json", nil)
+		for k, v := range test.header {
+			r.Header[k] = v
+		}
+		cfg := &Config{Coalesce: true, CoalesceHeaders: test.headers}
+		if got := coalescable(r, cfg); got != test.want {
+			t.Errorf("Test %d: expected %v, got %v", i, test.want, got)
+		}
+	}
+}
+
+func TestCoalesceSetCookie(t *testing.T) {
+	var hits int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		Coalesce:      true,
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=leader")
+		w.Write([]byte(`{"title": "Personal"}`))
+		return http.StatusOK, nil
+	})
+	st.flights = newFlightGroup()
+
+	serve := func(rec *httptest.ResponseRecorder) {
+		if _, err := st.ServeHTTP(rec, httptest.NewRequest("GET", "/page.json", nil)); err != nil {
+			t.Error(err)
+		}
+	}
+
+	leader := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		serve(leader)
+		close(done)
+	}()
+	<-entered
+
+	// whether or not the follower joins the leader's render, it must
+	// end up with a render of its own
+	follower := httptest.NewRecorder()
+	followerStarted := make(chan struct{})
+	followerDone := make(chan struct{})
+	go func() {
+		close(followerStarted)
+		serve(follower)
+		close(followerDone)
+	}()
+	<-followerStarted
+	close(release)
+	<-done
+	<-followerDone
+
+	if hits != 2 {
+		t.Errorf("Expected the follower to render its own page, got %d upstream hits", hits)
+	}
+}
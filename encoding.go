@@ -0,0 +1,95 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// decodeResponse decodes body according to the Content-Encoding in
+// header and removes the encoding and length headers, which no longer
+// apply. Decoded bodies larger than max bytes are an error, unless max is
+// zero. The body is returned as is if it isn't encoded.
+func decodeResponse(header http.Header, body []byte, max int64) ([]byte, error) {
+	var encodings []string
+	for _, v := range header["Content-Encoding"] {
+		for _, enc := range strings.Split(v, ",") {
+			if enc = strings.ToLower(strings.TrimSpace(enc)); enc != "" && enc != "identity" {
+				encodings = append(encodings, enc)
+			}
+		}
+	}
+	if len(encodings) == 0 {
+		header.Del("Content-Encoding")
+		return body, nil
+	}
+
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		if body, err = decodeBody(encodings[i], body, max); err != nil {
+			return nil, err
+		}
+	}
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	return body, nil
+}
+
+// decodeBody undoes a single content coding.
+func decodeBody(encoding string, body []byte, max int64) ([]byte, error) {
+	var r io.Reader
+	switch encoding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	case "deflate":
+		// deflate is meant to be zlib wrapped, but some servers send it raw
+		if zr, err := zlib.NewReader(bytes.NewReader(body)); err == nil {
+			defer zr.Close()
+			r = zr
+		} else {
+			fr := flate.NewReader(bytes.NewReader(body))
+			defer fr.Close()
+			r = fr
+		}
+	default:
+		return nil, fmt.Errorf("stencil: unsupported upstream content encoding %q", encoding)
+	}
+
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if max > 0 && int64(len(decoded)) > max {
+		return nil, errBodyTooLarge
+	}
+	return decoded, nil
+}
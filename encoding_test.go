@@ -0,0 +1,87 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestDecodeResponse(t *testing.T) {
+	const doc = `{"title": "Hello"}`
+	encode := func(newWriter func(io.Writer) io.WriteCloser) []byte {
+		var b bytes.Buffer
+		w := newWriter(&b)
+		w.Write([]byte(doc))
+		w.Close()
+		return b.Bytes()
+	}
+	gzipped := encode(func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) })
+	zlibbed := encode(func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) })
+	raw := encode(func(w io.Writer) io.WriteCloser {
+		fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+		return fw
+	})
+
+	tests := []struct {
+		encoding string
+		body     []byte
+		max      int64
+		err      bool
+	}{
+		{"", []byte(doc), 0, false},
+		{"identity", []byte(doc), 0, false},
+		{"gzip", gzipped, 0, false},
+		{"X-Gzip", gzipped, 0, false},
+		{"deflate", zlibbed, 0, false},
+		{"deflate", raw, 0, false},
+		{"gzip", gzipped, 5, true},
+		{"gzip", []byte(doc), 0, true},
+		{"br", []byte(doc), 0, true},
+	}
+
+	for i, test := range tests {
+		header := http.Header{"Content-Length": {"42"}}
+		if test.encoding != "" {
+			header.Set("Content-Encoding", test.encoding)
+		}
+		body, err := decodeResponse(header, test.body, test.max)
+		if test.err {
+			if err == nil {
+				t.Errorf("Test %d: expected an error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if string(body) != doc {
+			t.Errorf("Test %d: expected %q, got %q", i, doc, body)
+		}
+		if header.Get("Content-Encoding") != "" {
+			t.Errorf("Test %d: expected Content-Encoding to be removed", i)
+		}
+	}
+
+	if _, err := decodeResponse(http.Header{"Content-Encoding": {"gzip"}}, gzipped, 5); err != errBodyTooLarge {
+		t.Errorf("Expected errBodyTooLarge, got %v", err)
+	}
+}
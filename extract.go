@@ -0,0 +1,92 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+	"golang.org/x/net/html"
+)
+
+// Extract is a named part of upstream HTML, made available to templates
+// as .Doc.fragments.<Name>.
+type Extract struct {
+	// Name the fragment is available under
+	Name string
+
+	// CSS selector of the elements to extract
+	Selector string
+
+	match cascadia.Selector
+}
+
+// NewExtract returns an Extract for the elements matching selector.
+func NewExtract(name, selector string) (Extract, error) {
+	match, err := cascadia.Compile(selector)
+	if err != nil {
+		return Extract{}, err
+	}
+	return Extract{Name: name, Selector: selector, match: match}, nil
+}
+
+// Select returns the HTML of the elements in doc that match selector. For
+// example:
+//
+//	{{ .Select .Doc.body "#content" }}
+func (d Data) Select(doc, selector string) (string, error) {
+	match, err := cascadia.Compile(selector)
+	if err != nil {
+		return "", err
+	}
+	root, err := html.Parse(strings.NewReader(doc))
+	if err != nil {
+		return "", err
+	}
+	return renderMatches(root, match)
+}
+
+// extractFragments returns the HTML of each of extracts in doc by name.
+func extractFragments(doc []byte, extracts []Extract) (map[string]interface{}, error) {
+	root, err := html.Parse(bytes.NewReader(doc))
+	if err != nil {
+		return nil, err
+	}
+	fragments := make(map[string]interface{}, len(extracts))
+	for _, e := range extracts {
+		match := e.match
+		if match == nil {
+			if match, err = cascadia.Compile(e.Selector); err != nil {
+				return nil, err
+			}
+		}
+		if fragments[e.Name], err = renderMatches(root, match); err != nil {
+			return nil, err
+		}
+	}
+	return fragments, nil
+}
+
+// renderMatches returns the HTML of the elements below root that match.
+func renderMatches(root *html.Node, match cascadia.Selector) (string, error) {
+	var b bytes.Buffer
+	for _, n := range match.MatchAll(root) {
+		if err := html.Render(&b, n); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,56 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+const legacyPage = `<html><body>
+<div id="nav"><a href="/">Home</a></div>
+<div id="content"><p>Sunny</p></div>
+<table class="results"><tr><td>1</td></tr></table>
+</body></html>`
+
+func TestExtract(t *testing.T) {
+	content, err := NewExtract("content", "#content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cells, err := NewExtract("cells", "table.results td")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewExtract("bad", "div["); err == nil {
+		t.Error("Expected invalid selector to fail")
+	}
+
+	cfg := &Config{
+		Template:      template.Must(template.New("").Parse(`{{ .Doc.fragments.content }}|{{ .Doc.fragments.cells }}|{{ .Select .Doc.body "#nav a" }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		Extracts:      []Extract{content, cells},
+	}
+	out, err := cfg.Stencil("page", bytes.NewBufferString(legacyPage), Data{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `<div id="content"><p>Sunny</p></div>|<td>1</td>|<a href="/">Home</a>`
+	if got := strings.TrimSpace(string(out)); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultFallbackSize is the number of last good pages kept in memory.
+const defaultFallbackSize = 1000
+
+// staleWarning is the Warning header sent with pages served because the
+// upstream failed.
+const staleWarning = `111 - "Revalidation Failed"`
+
+// fallbackStore keeps the last successful render of each URL so that it
+// can be served when the upstream fails.
+type fallbackStore struct {
+	mu      sync.Mutex
+	size    int
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+// fallbackEntry is the last good page of a URL and when it was rendered.
+type fallbackEntry struct {
+	key    string
+	page   *page
+	stored time.Time
+}
+
+// storedPage is the on-disk form of a fallback entry.
+type storedPage struct {
+	Status   int
+	Header   http.Header
+	Body     []byte
+	Redirect string
+	ModTime  time.Time
+	Template string
+	Stored   time.Time
+}
+
+func newFallbackStore(size int) *fallbackStore {
+	return &fallbackStore{
+		size:    size,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the last good page for r if it is no older than the
+// maximum staleness of cfg, or nil if there is none. Pages that are not
+// in memory are read from the fallback directory, if configured.
+func (f *fallbackStore) get(r *http.Request, cfg *Config, now time.Time) *page {
+	key := primaryCacheKey(r, cfg)
+
+	f.mu.Lock()
+	el, ok := f.entries[key]
+	if ok {
+		f.lru.MoveToFront(el)
+	}
+	f.mu.Unlock()
+
+	var e *fallbackEntry
+	if ok {
+		e = el.Value.(*fallbackEntry)
+	} else if cfg.FallbackDir != "" {
+		if e = readFallback(cfg.FallbackDir, key); e != nil {
+			f.add(e)
+		}
+	}
+	if e == nil || now.Sub(e.stored) > cfg.FallbackStale {
+		return nil
+	}
+	return e.page
+}
+
+// put records p as the last good page for r, unless it is meant for a
+// single client. Pages have no variants in the store, so pages rendered
+// for requests with cookies are not kept either. It reports whether p
+// was stored.
+func (f *fallbackStore) put(r *http.Request, cfg *Config, p *page) bool {
+	if p.status >= 300 || !sharedPage(p) || !cookiesVaried(r, nil) {
+		return false
+	}
+	e := &fallbackEntry{
+		key:    primaryCacheKey(r, cfg),
+		page:   p,
+		stored: time.Now(),
+	}
+	f.add(e)
+
+	if cfg.FallbackDir != "" {
+		if err := writeFallback(cfg.FallbackDir, e); err != nil {
+			log.Printf("[ERROR] stencil: saving fallback page for %s: %v", r.URL.Path, err)
+		}
+	}
+	return true
+}
+
+func (f *fallbackStore) add(e *fallbackEntry) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if el, ok := f.entries[e.key]; ok {
+		el.Value = e
+		f.lru.MoveToFront(el)
+		return
+	}
+	f.entries[e.key] = f.lru.PushFront(e)
+	for f.lru.Len() > f.size {
+		el := f.lru.Back()
+		f.lru.Remove(el)
+		delete(f.entries, el.Value.(*fallbackEntry).key)
+	}
+}
+
+// fallbackFile returns the path of the file holding the page for key.
+func fallbackFile(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".page")
+}
+
+// readFallback reads the page for key from dir. It returns nil if there
+// is no usable page.
+func readFallback(dir, key string) *fallbackEntry {
+	file, err := os.Open(fallbackFile(dir, key))
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	var sp storedPage
+	if err := gob.NewDecoder(file).Decode(&sp); err != nil {
+		return nil
+	}
+	// never hand out cookies meant for whoever the page was rendered for
+	sp.Header.Del("Set-Cookie")
+	return &fallbackEntry{
+		key: key,
+		page: &page{
+			status:   sp.Status,
+			header:   sp.Header,
+			body:     sp.Body,
+			redirect: sp.Redirect,
+			modTime:  sp.ModTime,
+			template: sp.Template,
+		},
+		stored: sp.Stored,
+	}
+}
+
+// writeFallback saves e in dir. The file is replaced atomically so that
+// readers never see a partial page.
+func writeFallback(dir string, e *fallbackEntry) error {
+	tmp, err := ioutil.TempFile(dir, "tmp-")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(tmp).Encode(storedPage{
+		Status:   e.page.status,
+		Header:   e.page.header,
+		Body:     e.page.body,
+		Redirect: e.page.redirect,
+		ModTime:  e.page.modTime,
+		Template: e.page.template,
+		Stored:   e.stored,
+	})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), fallbackFile(dir, e.key))
+}
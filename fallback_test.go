@@ -0,0 +1,151 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFallbackStale(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stencil-fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		FallbackStale: time.Hour,
+		FallbackDir:   dir,
+	}
+	failing := false
+	newStencil := func() Stencil {
+		st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+			if failing {
+				return http.StatusBadGateway, errors.New("upstream timed out")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"title": "Last good"}`))
+			return http.StatusOK, nil
+		})
+		st.fallback = newFallbackStore(defaultFallbackSize)
+		return st
+	}
+	get := func(st Stencil) (*httptest.ResponseRecorder, int, error) {
+		req, err := http.NewRequest("GET", "/page.json", nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		code, err := st.ServeHTTP(rec, req)
+		return rec, code, err
+	}
+
+	st := newStencil()
+	if _, _, err := get(st); err != nil {
+		t.Fatal(err)
+	}
+
+	failing = true
+	rec, _, err := get(st)
+	if err != nil {
+		t.Fatalf("Expected the last good page, got error %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "<title>Last good</title>") {
+		t.Errorf("Expected the last good page, got %q", rec.Body.String())
+	}
+	if rec.Header().Get("Warning") == "" {
+		t.Error("Expected a Warning header on the stale page")
+	}
+
+	// a new instance finds the page on disk
+	rec, _, err = get(newStencil())
+	if err != nil {
+		t.Fatalf("Expected the page from disk, got error %v", err)
+	}
+	if !strings.Contains(rec.Body.String(), "<title>Last good</title>") {
+		t.Errorf("Expected the page from disk, got %q", rec.Body.String())
+	}
+
+	// pages older than the maximum staleness are not used
+	cfg.FallbackStale = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, code, err := get(newStencil()); err == nil || code != http.StatusBadGateway {
+		t.Errorf("Expected upstream error once the page is too stale, got %d, %v", code, err)
+	}
+}
+
+func TestFallbackPrivatePages(t *testing.T) {
+	cfg := &Config{PathScope: "/", FallbackStale: time.Hour}
+	f := newFallbackStore(defaultFallbackSize)
+
+	tests := []struct {
+		header http.Header
+		cookie string
+		stored bool
+	}{
+		{http.Header{}, "", true},
+		{http.Header{"Set-Cookie": {"session=abc"}}, "", false},
+		{http.Header{"Cache-Control": {"private, max-age=60"}}, "", false},
+		{http.Header{"Cache-Control": {"no-store"}}, "", false},
+		{http.Header{}, "session=abc", false},
+	}
+	for i, test := range tests {
+		r, _ := http.NewRequest("GET", "/page.json", nil)
+		if test.cookie != "" {
+			r.Header.Set("Cookie", test.cookie)
+		}
+		if got := f.put(r, cfg, &page{header: test.header}); got != test.stored {
+			t.Errorf("Test %d: expected stored to be %v, got %v", i, test.stored, got)
+		}
+	}
+}
+
+func TestReadFallbackDropsCookies(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stencil-fallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	e := &fallbackEntry{
+		key:    "GET /page.json",
+		page:   &page{header: http.Header{"Set-Cookie": {"session=abc"}, "X-Kept": {"yes"}}},
+		stored: time.Now(),
+	}
+	if err := writeFallback(dir, e); err != nil {
+		t.Fatal(err)
+	}
+	read := readFallback(dir, e.key)
+	if read == nil {
+		t.Fatal("Expected the page to be read back")
+	}
+	if _, ok := read.page.header["Set-Cookie"]; ok {
+		t.Error("Expected Set-Cookie to be dropped from the stored page")
+	}
+	if read.page.header.Get("X-Kept") != "yes" {
+		t.Error("Expected other headers to be kept")
+	}
+}
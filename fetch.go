@@ -0,0 +1,216 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/jimjimovich/caddy-stencil/metadata"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// FetchDepthHeader is set on subrequests made by templates. Pages that
+// are requested this way, for example through a loopback proxy, can only
+// fetch if the depth is still below the configured maximum.
+const FetchDepthHeader = "X-Stencil-Fetch-Depth"
+
+const (
+	// defaultFetchLimit is the number of subrequests a render may make if
+	// no limit is configured.
+	defaultFetchLimit = 10
+
+	// defaultFetchDepth is how deeply fetched pages may fetch in turn if
+	// no depth is configured.
+	defaultFetchDepth = 2
+)
+
+// fetchDepthKey is the context key holding the depth of subrequests
+// made in-process.
+type fetchDepthKey struct{}
+
+// fetchDepth returns how deeply r is nested in fetches. Subrequests that
+// come back through a loopback connection only carry the header, which is
+// sent by clients too, so values that would raise the allowed depth are
+// ignored.
+func fetchDepth(r *http.Request) int {
+	if depth, ok := r.Context().Value(fetchDepthKey{}).(int); ok {
+		return depth
+	}
+	depth, err := strconv.Atoi(r.Header.Get(FetchDepthHeader))
+	if err != nil || depth < 0 {
+		return 0
+	}
+	return depth
+}
+
+// fetcher makes the subrequests of a single render.
+type fetcher struct {
+	next  httpserver.Handler
+	req   *http.Request
+	cfg   *Config
+	depth int
+	count int
+
+	// context of the render, which bounds the subrequests
+	ctx context.Context
+}
+
+func newFetcher(next httpserver.Handler, r *http.Request, cfg *Config) *fetcher {
+	return &fetcher{
+		next:  next,
+		req:   r,
+		cfg:   cfg,
+		depth: fetchDepth(r),
+		ctx:   r.Context(),
+	}
+}
+
+// Fetch requests target from the rest of the handler chain and returns
+// its parsed data. target is resolved against the URL being rendered and
+// must be on the same host. For example:
+//
+//	{{ $user := .Fetch "/api/users/42.json" }}
+func (d Data) Fetch(target string) (interface{}, error) {
+	if d.fetcher == nil {
+		return nil, fmt.Errorf("stencil: fetch %s: not available", target)
+	}
+	return d.fetcher.fetch(target)
+}
+
+func (f *fetcher) fetch(target string) (interface{}, error) {
+	if f.count >= f.cfg.FetchLimit {
+		return nil, fmt.Errorf("stencil: fetch %s: more than %d fetches", target, f.cfg.FetchLimit)
+	}
+	if f.depth >= f.cfg.FetchDepth {
+		return nil, fmt.Errorf("stencil: fetch %s: nested deeper than %d", target, f.cfg.FetchDepth)
+	}
+	f.count++
+
+	sub, err := newSubrequest(f.ctx, f.req, f.cfg, target, f.depth+1)
+	if err != nil {
+		return nil, fmt.Errorf("stencil: fetch %s: %v", target, err)
+	}
+	data, err := doSubrequest(f.next, sub, f.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("stencil: fetch %s: %v", target, err)
+	}
+	return data, nil
+}
+
+// newSubrequest builds a GET request for target on behalf of r that is
+// canceled with ctx. target is resolved against the URL of r and must be
+// on the same host.
+func newSubrequest(ctx context.Context, r *http.Request, cfg *Config, target string, depth int) (*http.Request, error) {
+	u, err := r.URL.Parse(target)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return nil, errors.New("only local paths can be fetched")
+	}
+
+	sub, err := http.NewRequest(http.MethodGet, u.RequestURI(), nil)
+	if err != nil {
+		return nil, err
+	}
+	sub.Host = r.Host
+	sub.RemoteAddr = r.RemoteAddr
+	sub.TLS = r.TLS
+	for _, name := range cfg.FetchHeaders {
+		if v, ok := r.Header[name]; ok {
+			sub.Header[name] = v
+		}
+	}
+	rewriteUpstreamHeaders(sub.Header, cfg)
+	sub.Header.Set(FetchDepthHeader, strconv.Itoa(depth))
+	ctx = context.WithValue(ctx, fetchDepthKey{}, depth)
+	return sub.WithContext(context.WithValue(ctx, httpserver.OriginalURLCtxKey, *sub.URL)), nil
+}
+
+// doSubrequest passes sub to next and returns the parsed data of the
+// response.
+func doSubrequest(next httpserver.Handler, sub *http.Request, cfg *Config) (interface{}, error) {
+	rw := newSubResponseWriter(cfg.MaxBody)
+	code, err := next.ServeHTTP(rw, sub)
+	if rw.exceeded {
+		return nil, errBodyTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	if rw.status != 0 {
+		code = rw.status
+	}
+	if code >= 400 {
+		return nil, fmt.Errorf("status %d", code)
+	}
+
+	body, err := decodeResponse(rw.header, rw.body.Bytes(), cfg.MaxBody)
+	if err != nil {
+		return nil, err
+	}
+	format := metadata.DataFormat(path.Ext(sub.URL.Path), rw.header.Get("Content-Type"))
+	if !metadata.IsBinary(format) {
+		if body, err = toUTF8(body, rw.header.Get("Content-Type"), ""); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkInput(body, format, cfg); err != nil {
+		return nil, err
+	}
+	parser, err := metadata.GetDataParser(body, format, cfg.MaxDepth, cfg.MaxElements)
+	if err != nil {
+		return nil, err
+	}
+	return parser.Metadata().Variables["data"], nil
+}
+
+// subResponseWriter holds the response to a subrequest in memory.
+type subResponseWriter struct {
+	header   http.Header
+	status   int
+	body     bytes.Buffer
+	max      int64
+	exceeded bool
+}
+
+func newSubResponseWriter(max int64) *subResponseWriter {
+	return &subResponseWriter{header: make(http.Header), max: max}
+}
+
+func (s *subResponseWriter) Header() http.Header {
+	return s.header
+}
+
+func (s *subResponseWriter) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+}
+
+func (s *subResponseWriter) Write(b []byte) (int, error) {
+	s.WriteHeader(http.StatusOK)
+	if s.max > 0 && int64(s.body.Len()+len(b)) > s.max {
+		s.exceeded = true
+		return 0, errBodyTooLarge
+	}
+	return s.body.Write(b)
+}
@@ -0,0 +1,217 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestFetch(t *testing.T) {
+	docs := map[string]string{
+		"/page.json":     `{"user": "/users/42.json"}`,
+		"/users/42.json": `{"name": "Ada"}`,
+	}
+	var forwarded string
+
+	cfg := &Config{
+		PathScope:     "/page.json",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      template.Must(template.New("").Parse(`{{ $user := .Fetch .Doc.data.user }}Hello {{ $user.name }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		FetchLimit:    1,
+		FetchDepth:    1,
+		FetchHeaders:  []string{"Accept-Language"},
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		if r.URL.Path == "/users/42.json" {
+			forwarded = r.Header.Get("Accept-Language")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(doc))
+		return http.StatusOK, nil
+	})
+
+	get := func(header http.Header) (*httptest.ResponseRecorder, int, error) {
+		req, err := http.NewRequest("GET", "/page.json", nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		rec := httptest.NewRecorder()
+		code, err := st.ServeHTTP(rec, req)
+		return rec, code, err
+	}
+
+	rec, _, err := get(http.Header{"Accept-Language": {"en"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "Hello Ada" {
+		t.Errorf("Expected fetched data in page, got %q", got)
+	}
+	if forwarded != "en" {
+		t.Errorf("Expected Accept-Language to be passed on, got %q", forwarded)
+	}
+
+	// requests that were themselves fetched can't fetch beyond the depth
+	if _, code, err := get(http.Header{FetchDepthHeader: {"1"}}); err == nil || code != http.StatusInternalServerError {
+		t.Errorf("Expected fetch beyond depth to fail, got %d, %v", code, err)
+	}
+
+	cfg.FetchLimit = 0
+	if _, code, err := get(nil); err == nil || code != http.StatusInternalServerError {
+		t.Errorf("Expected fetch beyond limit to fail, got %d, %v", code, err)
+	}
+}
+
+func TestFetchDepth(t *testing.T) {
+	tests := []struct {
+		header string
+		want   int
+	}{
+		{"", 0},
+		{"1", 1},
+		{"-5", 0},
+		{"lots", 0},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/page.json", nil)
+		r.Header.Set(FetchDepthHeader, test.header)
+		if got := fetchDepth(r); got != test.want {
+			t.Errorf("Header %q: expected depth %d, got %d", test.header, test.want, got)
+		}
+	}
+
+	// the depth of in-process subrequests can't be changed by headers
+	r := httptest.NewRequest("GET", "/page.json", nil)
+	sub, err := newSubrequest(r.Context(), r, &Config{}, "/other.json", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub.Header.Set(FetchDepthHeader, "0")
+	if got := fetchDepth(sub); got != 2 {
+		t.Errorf("Expected subrequest depth 2, got %d", got)
+	}
+}
+
+func TestFetchRenderTimeout(t *testing.T) {
+	cfg := &Config{
+		PathScope:     "/page.json",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      template.Must(template.New("").Parse(`{{ .Fetch "/slow.json" }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		FetchLimit:    1,
+		FetchDepth:    1,
+		RenderTimeout: 10 * time.Millisecond,
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if r.URL.Path == "/slow.json" {
+			select {
+			case <-r.Context().Done():
+				return http.StatusGatewayTimeout, r.Context().Err()
+			case <-time.After(5 * time.Second):
+				return http.StatusOK, nil
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/page.json", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	start := time.Now()
+	if _, err := st.ServeHTTP(httptest.NewRecorder(), req); err == nil {
+		t.Error("Expected the fetch to be cut off by the render timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the render to stop at the render timeout, took %v", elapsed)
+	}
+}
+
+func TestFetchDuringTemplateUpdate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stencil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tpl := filepath.Join(dir, "page.html")
+	const page = `{{ if eq .URL.Path "/outer.json" }}{{ $x := .Fetch "/loop.json" }}{{ end }}`
+	if err := ioutil.WriteFile(tpl, []byte(page+"old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: map[string]*CachedFileInfo{"": {Path: tpl}},
+		FetchLimit:    1,
+		FetchDepth:    1,
+	}
+	var st Stencil
+	inner := httptest.NewRecorder()
+	st = newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if r.URL.Path == "/loop.json" {
+			// edit the template and render another page with it while
+			// the outer render is still fetching
+			if err := ioutil.WriteFile(tpl, []byte(page+"new!"), 0644); err != nil {
+				return http.StatusInternalServerError, err
+			}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if _, err := st.ServeHTTP(inner, httptest.NewRequest("GET", "/inner.json", nil)); err != nil {
+					t.Error(err)
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Error("Expected the second render not to wait for the fetch")
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+		return http.StatusOK, nil
+	})
+
+	outer := httptest.NewRecorder()
+	if _, err := st.ServeHTTP(outer, httptest.NewRequest("GET", "/outer.json", nil)); err != nil {
+		t.Fatal(err)
+	}
+	if got := outer.Body.String(); got != "old" {
+		t.Errorf("Expected the outer render to keep its template, got %q", got)
+	}
+	if got := inner.Body.String(); got != "new!" {
+		t.Errorf("Expected the second render to use the updated template, got %q", got)
+	}
+}
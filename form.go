@@ -0,0 +1,84 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// maxFormBody is the largest request body that is read for .Form.
+	maxFormBody = 10 << 20
+
+	// maxFormMemory is how much of a multipart form is kept in memory,
+	// the rest of it is stored in temporary files.
+	maxFormMemory = 32 << 20
+)
+
+var errFormTooLarge = errors.New("stencil: request body too large")
+
+// defaultMethods are the request methods rendered if none are configured.
+var defaultMethods = map[string]struct{}{
+	http.MethodGet:  {},
+	http.MethodHead: {},
+}
+
+// renders reports whether requests with method are rendered.
+func (c *Config) renders(method string) bool {
+	methods := c.Methods
+	if len(methods) == 0 {
+		methods = defaultMethods
+	}
+	_, ok := methods[method]
+	return ok
+}
+
+// readForm returns the form values of the query and body of r. The body
+// is read into memory and replaced, so that it can still be passed
+// upstream.
+func readForm(r *http.Request) (url.Values, error) {
+	if r.Body == nil || r.Body == http.NoBody || r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return r.URL.Query(), nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxFormBody+1))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxFormBody {
+		return nil, errFormTooLarge
+	}
+
+	// parse a copy so that r is left as it was
+	fr := new(http.Request)
+	*fr = *r
+	fr.Body = ioutil.NopCloser(bytes.NewReader(body))
+	fr.Form, fr.PostForm, fr.MultipartForm = nil, nil, nil
+	err = fr.ParseMultipartForm(maxFormMemory)
+	if fr.MultipartForm != nil {
+		fr.MultipartForm.RemoveAll()
+	}
+	if err != nil && err != http.ErrNotMultipart {
+		return nil, err
+	}
+	return fr.Form, nil
+}
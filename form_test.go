@@ -0,0 +1,72 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestForm(t *testing.T) {
+	var upstreamBody string
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      template.Must(template.New("").Parse(`{{ .Form.Get "name" }} {{ .Form.Get "page" }} {{ .Doc.data.status }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		Methods:       map[string]struct{}{http.MethodGet: {}, http.MethodPost: {}},
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return http.StatusInternalServerError, err
+		}
+		upstreamBody = string(b)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status": "saved"}`))
+		return http.StatusOK, nil
+	})
+
+	post := func(method string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, "/submit.json?page=2", strings.NewReader("name=Ada"))
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		if _, err := st.ServeHTTP(rec, req); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	rec := post(http.MethodPost)
+	if got, want := strings.TrimSpace(rec.Body.String()), "Ada 2 saved"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+	if upstreamBody != "name=Ada" {
+		t.Errorf("Expected request body to be passed upstream, got %q", upstreamBody)
+	}
+
+	// methods that aren't configured are passed through untouched
+	rec = post(http.MethodPut)
+	if got, want := rec.Body.String(), `{"status": "saved"}`; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
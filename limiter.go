@@ -0,0 +1,117 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// defaultRenderWait is how long a render waits in the queue if no wait
+// timeout is configured.
+const defaultRenderWait = 10 * time.Second
+
+// errSaturated is returned when no render slot became available in time.
+var errSaturated = errors.New("stencil: too many concurrent renders")
+
+// renderLimiter bounds the number of templates executing at once. Renders
+// over the limit wait in a queue of bounded depth.
+type renderLimiter struct {
+	// accessed atomically, keep first for 64-bit alignment
+	queued   int64
+	rejected uint64
+	timedOut uint64
+
+	slots      chan struct{}
+	queueDepth int64
+	wait       time.Duration
+}
+
+// limiterStats is a snapshot of the state of a renderLimiter.
+type limiterStats struct {
+	Path       string `json:"path"`
+	Limit      int    `json:"limit"`
+	Active     int    `json:"active"`
+	QueueDepth int64  `json:"queue_depth"`
+	Queued     int64  `json:"queued"`
+	Rejected   uint64 `json:"rejected"`
+	TimedOut   uint64 `json:"timed_out"`
+}
+
+func newRenderLimiter(limit, queueDepth int, wait time.Duration) *renderLimiter {
+	return &renderLimiter{
+		slots:      make(chan struct{}, limit),
+		queueDepth: int64(queueDepth),
+		wait:       wait,
+	}
+}
+
+// acquire takes a render slot, waiting in the queue if none is free. It
+// returns errSaturated if the queue is full or the wait timed out.
+func (l *renderLimiter) acquire(ctx context.Context) error {
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	default:
+	}
+
+	if atomic.AddInt64(&l.queued, 1) > l.queueDepth {
+		atomic.AddInt64(&l.queued, -1)
+		atomic.AddUint64(&l.rejected, 1)
+		return errSaturated
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+
+	timer := time.NewTimer(l.wait)
+	defer timer.Stop()
+
+	select {
+	case l.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		atomic.AddUint64(&l.timedOut, 1)
+		return errSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release gives back a slot taken by acquire.
+func (l *renderLimiter) release() {
+	<-l.slots
+}
+
+// retryAfter is the number of seconds clients are asked to wait before
+// retrying when the limiter is saturated.
+func (l *renderLimiter) retryAfter() int {
+	secs := int(l.wait / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return secs
+}
+
+func (l *renderLimiter) stats() limiterStats {
+	return limiterStats{
+		Limit:      cap(l.slots),
+		Active:     len(l.slots),
+		QueueDepth: l.queueDepth,
+		Queued:     atomic.LoadInt64(&l.queued),
+		Rejected:   atomic.LoadUint64(&l.rejected),
+		TimedOut:   atomic.LoadUint64(&l.timedOut),
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRenderLimiter(t *testing.T) {
+	ctx := context.Background()
+
+	// without a queue, renders over the limit are rejected right away
+	l := newRenderLimiter(1, 0, time.Second)
+	if err := l.acquire(ctx); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+	if err := l.acquire(ctx); err != errSaturated {
+		t.Errorf("Expected %v, got %v", errSaturated, err)
+	}
+	if stats := l.stats(); stats.Active != 1 || stats.Rejected != 1 {
+		t.Errorf("Expected 1 active and 1 rejected render, got %+v", stats)
+	}
+
+	// queued renders give up after the wait timeout
+	l = newRenderLimiter(1, 1, 10*time.Millisecond)
+	if err := l.acquire(ctx); err != nil {
+		t.Fatalf("Expected a free slot, got %v", err)
+	}
+	if err := l.acquire(ctx); err != errSaturated {
+		t.Errorf("Expected %v, got %v", errSaturated, err)
+	}
+	if stats := l.stats(); stats.TimedOut != 1 || stats.Queued != 0 {
+		t.Errorf("Expected 1 timed out and no queued render, got %+v", stats)
+	}
+
+	// queued renders get the slot once it is released
+	done := make(chan error)
+	go func() {
+		done <- l.acquire(ctx)
+	}()
+	time.Sleep(time.Millisecond)
+	l.release()
+	if err := <-done; err != nil {
+		t.Errorf("Expected the released slot, got %v", err)
+	}
+}
+
+func TestRenderLimitPerConfig(t *testing.T) {
+	newConfig := func(scope string) *Config {
+		return &Config{
+			PathScope:     scope,
+			Extensions:    map[string]struct{}{".json": {}},
+			Template:      GetDefaultTemplate(),
+			TemplateFiles: make(map[string]*CachedFileInfo),
+		}
+	}
+	limited, open := newConfig("/limited"), newConfig("/open")
+	limited.limiter = newRenderLimiter(1, 0, time.Second)
+	st := newTestStencil(limited, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "Page"}`))
+		return http.StatusOK, nil
+	})
+	st.Configs = append(st.Configs, open)
+
+	// take the only slot of the limited block
+	if err := limited.limiter.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer limited.limiter.release()
+
+	tests := []struct {
+		url  string
+		code int
+	}{
+		{"/limited/page.json", http.StatusServiceUnavailable},
+		{"/open/page.json", 0},
+	}
+	for _, test := range tests {
+		code, _ := st.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.url, nil))
+		if code != test.code {
+			t.Errorf("%s: expected status %d, got %d", test.url, test.code, code)
+		}
+	}
+}
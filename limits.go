@@ -0,0 +1,138 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jimjimovich/caddy-stencil/metadata"
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// defaultMaxLines is the number of lines NDJSON input may have if no
+// limit is configured.
+const defaultMaxLines = 10000
+
+// maxPooledBufferSize is the capacity above which buffers are left to the
+// garbage collector instead of being returned to the pool.
+const maxPooledBufferSize = 1 << 20
+
+// errBodyTooLarge is returned when the upstream body exceeds max_body.
+var errBodyTooLarge = errors.New("stencil: upstream body exceeds max_body")
+
+var (
+	// errRenderTimeout is returned when a render takes longer than render_timeout.
+	errRenderTimeout = errors.New("stencil: render exceeded render_timeout")
+
+	// errOutputTooLarge is returned when a render produces more than max_output.
+	errOutputTooLarge = errors.New("stencil: rendered output exceeds max_output")
+)
+
+// renderGuardWriter aborts template execution by failing writes once the
+// output grows beyond max bytes or ctx is done. Templates that loop
+// without producing output can't be stopped this way.
+type renderGuardWriter struct {
+	w       io.Writer
+	ctx     context.Context
+	max     int64
+	written int64
+}
+
+func (g *renderGuardWriter) Write(b []byte) (int, error) {
+	if err := g.ctx.Err(); err != nil {
+		if err == context.DeadlineExceeded {
+			return 0, errRenderTimeout
+		}
+		return 0, err
+	}
+	if g.max > 0 && g.written+int64(len(b)) > g.max {
+		return 0, errOutputTooLarge
+	}
+	n, err := g.w.Write(b)
+	g.written += int64(n)
+	return n, err
+}
+
+// bodyLimitWriter stops the upstream handler from buffering more than max
+// bytes. Responses that are passed through unbuffered are not limited.
+type bodyLimitWriter struct {
+	*httpserver.ResponseBuffer
+	max      int64
+	exceeded bool
+}
+
+func (lw *bodyLimitWriter) Write(b []byte) (int, error) {
+	// let the buffer decide whether it buffers this response
+	lw.ResponseBuffer.WriteHeader(http.StatusOK)
+
+	if lw.Buffered() && int64(lw.Buffer.Len()+len(b)) > lw.max {
+		lw.exceeded = true
+		return 0, errBodyTooLarge
+	}
+	return lw.ResponseBuffer.Write(b)
+}
+
+// ReadFrom keeps the ResponseBuffer's ReadFrom from bypassing the limit.
+func (lw *bodyLimitWriter) ReadFrom(src io.Reader) (int64, error) {
+	lw.ResponseBuffer.WriteHeader(http.StatusOK)
+	if !lw.Buffered() {
+		return lw.ResponseBuffer.ReadFrom(src)
+	}
+	return io.Copy(struct{ io.Writer }{lw}, src)
+}
+
+// checkInput checks a document in format against the input limits of
+// cfg before it is parsed. Binary documents are checked by their parsers
+// instead.
+func checkInput(body []byte, format string, cfg *Config) error {
+	switch {
+	case format == metadata.FormatNDJSON:
+		return metadata.CheckNDJSON(body, cfg.MaxLines, cfg.MaxDepth, cfg.MaxElements)
+	case metadata.IsBinary(format):
+		return nil
+	}
+	return metadata.CheckJSON(body, cfg.MaxDepth, cfg.MaxElements)
+}
+
+// parseSize parses a size in bytes with an optional KB, MB or GB suffix.
+func parseSize(s string) (int64, error) {
+	mult := int64(1)
+	upper := strings.ToUpper(s)
+	for _, unit := range []struct {
+		suffix string
+		mult   int64
+	}{
+		{"KB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(upper, unit.suffix) {
+			upper = strings.TrimSuffix(upper, unit.suffix)
+			mult = unit.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil || n < 1 {
+		return 0, errors.New("invalid size: " + s)
+	}
+	return n * mult, nil
+}
@@ -0,0 +1,164 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/jimjimovich/caddy-stencil/metadata"
+)
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		input     string
+		expected  int64
+		shouldErr bool
+	}{
+		{"512", 512, false},
+		{"64KB", 64 << 10, false},
+		{"10mb", 10 << 20, false},
+		{"1GB", 1 << 30, false},
+		{"0", 0, true},
+		{"lots", 0, true},
+	}
+
+	for i, test := range tests {
+		size, err := parseSize(test.input)
+		if test.shouldErr != (err != nil) {
+			t.Errorf("Test %d: expected error %v, got %v", i, test.shouldErr, err)
+		}
+		if size != test.expected {
+			t.Errorf("Test %d: expected %d, got %d", i, test.expected, size)
+		}
+	}
+}
+
+func TestMaxBody(t *testing.T) {
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		MaxBody:       16,
+		LimitStatus:   http.StatusRequestEntityTooLarge,
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/json")
+		if _, err := w.Write([]byte(`{"title": "Far too long for the limit"}`)); err != nil {
+			return http.StatusBadGateway, err
+		}
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/big.json", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	code, err := st.ServeHTTP(httptest.NewRecorder(), req)
+	if err != errBodyTooLarge {
+		t.Errorf("Expected error %v, got %v", errBodyTooLarge, err)
+	}
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".ndjson": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("{\"title\": \"Events\"}\nnot json\n"))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/events.ndjson", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	code, _ := st.ServeHTTP(rec, req)
+	if code != http.StatusBadGateway {
+		t.Errorf("Expected status %d, got %d", http.StatusBadGateway, code)
+	}
+	if strings.Contains(rec.Body.String(), "Events") {
+		t.Errorf("Expected invalid document not to be rendered, got %q", rec.Body.String())
+	}
+}
+
+func TestBinaryInputLimits(t *testing.T) {
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".msgpack": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		MaxElements:   2,
+		LimitStatus:   http.StatusRequestEntityTooLarge,
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/msgpack")
+		w.Write([]byte{0x93, 0x01, 0x02, 0x03}) // [1, 2, 3]
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/numbers.msgpack", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	code, err := st.ServeHTTP(httptest.NewRecorder(), req)
+	if err != metadata.ErrTooManyElements {
+		t.Errorf("Expected error %v, got %v", metadata.ErrTooManyElements, err)
+	}
+	if code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, code)
+	}
+}
+
+func TestRenderLimits(t *testing.T) {
+	input := `[1, 2, 3, 4, 5, 6, 7, 8, 9, 10]`
+	newConfig := func() *Config {
+		return &Config{
+			Template:      template.Must(template.New("").Parse(`{{ range .Doc.data }}0123456789{{ end }}`)),
+			TemplateFiles: make(map[string]*CachedFileInfo),
+		}
+	}
+
+	c := newConfig()
+	if _, err := c.Stencil("test", strings.NewReader(input), Data{}); err != nil {
+		t.Fatalf("Expected render without limits to succeed, got %v", err)
+	}
+
+	c = newConfig()
+	c.MaxOutput = 50
+	if _, err := c.Stencil("test", strings.NewReader(input), Data{}); err != errOutputTooLarge {
+		t.Errorf("Expected %v, got %v", errOutputTooLarge, err)
+	}
+
+	c = newConfig()
+	c.RenderTimeout = time.Nanosecond
+	time.Sleep(time.Millisecond)
+	if _, err := c.Stencil("test", strings.NewReader(input), Data{}); err != errRenderTimeout {
+		t.Errorf("Expected %v, got %v", errRenderTimeout, err)
+	}
+}
@@ -37,6 +37,9 @@ package metadata
 import (
 	"bufio"
 	"bytes"
+	"errors"
+	"mime"
+	"strings"
 )
 
 // Metadata stores a page's metadata
@@ -200,3 +203,99 @@ func splitBuffer(b *bytes.Buffer, delim string) (*bytes.Buffer, *bytes.Buffer) {
 
 	return meta, body
 }
+
+// Data formats that documents can be in as a whole.
+const (
+	FormatYAML    = "yaml"
+	FormatTOML    = "toml"
+	FormatNDJSON  = "ndjson"
+	FormatMsgpack = "msgpack"
+	FormatCBOR    = "cbor"
+)
+
+// DataFormat returns the data format named by a file extension or a
+// media type, or "" if neither names one. The extension wins.
+func DataFormat(ext, contentType string) string {
+	switch strings.ToLower(ext) {
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".toml":
+		return FormatTOML
+	case ".ndjson", ".jsonl":
+		return FormatNDJSON
+	case ".msgpack", ".mpk":
+		return FormatMsgpack
+	case ".cbor":
+		return FormatCBOR
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return FormatYAML
+	case "application/toml", "text/toml", "text/x-toml":
+		return FormatTOML
+	case "application/x-ndjson", "application/jsonl", "application/x-jsonlines":
+		return FormatNDJSON
+	case "application/msgpack", "application/x-msgpack", "application/vnd.msgpack":
+		return FormatMsgpack
+	case "application/cbor":
+		return FormatCBOR
+	}
+	return ""
+}
+
+// IsBinary reports whether documents in format are binary rather than
+// text.
+func IsBinary(format string) bool {
+	return format == FormatMsgpack || format == FormatCBOR
+}
+
+// ErrInvalidDocument is returned by GetDataParser when a document is not
+// valid in the format it was said to be in.
+var ErrInvalidDocument = errors.New("metadata: document is not valid in its format")
+
+// GetDataParser is like GetParser, but documents in format that have no
+// front matter are parsed as data as a whole, like JSON documents are.
+// Documents that are not valid in format are not parsed as anything else;
+// ErrInvalidDocument is returned instead. MessagePack and CBOR documents
+// can't be checked before they are parsed, so maxDepth and maxElements
+// are applied to them here and ErrTooDeep or ErrTooManyElements returned
+// if they are exceeded. Zero means no limit.
+func GetDataParser(by []byte, format string, maxDepth, maxElements int) (Parser, error) {
+	switch format {
+	case FormatYAML:
+		p := &YAMLParser{}
+		if p.Parse(by) || p.ParseData(by) {
+			return p, nil
+		}
+	case FormatTOML:
+		p := &TOMLParser{}
+		if p.Parse(by) || p.ParseData(by) {
+			return p, nil
+		}
+	case FormatNDJSON:
+		p := &NDJSONParser{}
+		if p.Parse(by) {
+			return p, nil
+		}
+	case FormatMsgpack:
+		p := &MsgpackParser{MaxDepth: maxDepth, MaxElements: maxElements}
+		if p.Parse(by) {
+			return p, nil
+		}
+		if err := p.Err(); err == ErrTooDeep || err == ErrTooManyElements {
+			return nil, err
+		}
+	case FormatCBOR:
+		p := &CBORParser{MaxDepth: maxDepth, MaxElements: maxElements}
+		if p.Parse(by) {
+			return p, nil
+		}
+		if err := p.Err(); err == ErrTooDeep || err == ErrTooManyElements {
+			return nil, err
+		}
+	default:
+		return GetParser(by), nil
+	}
+	return nil, ErrInvalidDocument
+}
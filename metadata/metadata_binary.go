@@ -0,0 +1,227 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+
+	"github.com/ugorji/go/codec"
+)
+
+var mapType = reflect.TypeOf(map[string]interface{}(nil))
+
+// MsgpackParser is the Parser for MessagePack documents. The data has the
+// same shape as that of JSONParser; the body is empty.
+type MsgpackParser struct {
+	// Limits on the nesting depth and number of values of documents,
+	// zero for no limit
+	MaxDepth    int
+	MaxElements int
+
+	metadata Metadata
+	body     *bytes.Buffer
+	err      error
+}
+
+// Type returns the kind of parser this struct is.
+func (m *MsgpackParser) Type() string {
+	return "MessagePack"
+}
+
+// Parse decodes the document and prepares the metadata and body.
+func (m *MsgpackParser) Parse(by []byte) bool {
+	m.metadata, m.err = decodeBinary(by, FormatMsgpack, m.MaxDepth, m.MaxElements)
+	if m.err != nil {
+		return false
+	}
+	m.body = bytes.NewBuffer(nil)
+	return true
+}
+
+// Err returns why the last call to Parse failed. It is ErrTooDeep or
+// ErrTooManyElements if the document exceeds the limits of the parser.
+func (m *MsgpackParser) Err() error {
+	return m.err
+}
+
+// Metadata returns parsed metadata. It should be called
+// only after a call to Parse returns true.
+func (m *MsgpackParser) Metadata() Metadata {
+	return m.metadata
+}
+
+// Body returns the body, which is always empty. It should be called
+// only after a call to Parse returns true.
+func (m *MsgpackParser) Body() []byte {
+	return m.body.Bytes()
+}
+
+// CBORParser is the Parser for CBOR documents. The data has the same
+// shape as that of JSONParser; the body is empty.
+type CBORParser struct {
+	// Limits on the nesting depth and number of values of documents,
+	// zero for no limit
+	MaxDepth    int
+	MaxElements int
+
+	metadata Metadata
+	body     *bytes.Buffer
+	err      error
+}
+
+// Type returns the kind of parser this struct is.
+func (c *CBORParser) Type() string {
+	return "CBOR"
+}
+
+// Parse decodes the document and prepares the metadata and body.
+func (c *CBORParser) Parse(by []byte) bool {
+	c.metadata, c.err = decodeBinary(by, FormatCBOR, c.MaxDepth, c.MaxElements)
+	if c.err != nil {
+		return false
+	}
+	c.body = bytes.NewBuffer(nil)
+	return true
+}
+
+// Err returns why the last call to Parse failed. It is ErrTooDeep or
+// ErrTooManyElements if the document exceeds the limits of the parser.
+func (c *CBORParser) Err() error {
+	return c.err
+}
+
+// Metadata returns parsed metadata. It should be called
+// only after a call to Parse returns true.
+func (c *CBORParser) Metadata() Metadata {
+	return c.metadata
+}
+
+// Body returns the body, which is always empty. It should be called
+// only after a call to Parse returns true.
+func (c *CBORParser) Body() []byte {
+	return c.body.Bytes()
+}
+
+// binaryHandle returns the codec handle for the binary format, which must
+// be FormatMsgpack or FormatCBOR. Decoding fails once values are nested
+// deeper than maxDepth, unless it is zero.
+func binaryHandle(format string, maxDepth int) codec.Handle {
+	// one more level than allowed, so that documents that are just too
+	// deep decode far enough for shaper to tell
+	depth := int16(math.MaxInt16)
+	if maxDepth > 0 && maxDepth < math.MaxInt16 {
+		depth = int16(maxDepth + 1)
+	}
+	if format == FormatMsgpack {
+		h := new(codec.MsgpackHandle)
+		h.RawToString = true
+		h.MapType = mapType
+		h.MaxDepth = depth
+		return h
+	}
+	h := new(codec.CborHandle)
+	h.MapType = mapType
+	h.MaxDepth = depth
+	return h
+}
+
+// decodeBinary decodes a single value from by, which must hold nothing
+// else, and returns it as data. Binary documents can't be scanned cheaply
+// like JSON, so the limits are applied while decoding: the codec stops as
+// soon as the document is nested too deeply, and values are counted as
+// they are converted.
+func decodeBinary(by []byte, format string, maxDepth, maxElements int) (Metadata, error) {
+	var v interface{}
+	dec := codec.NewDecoderBytes(by, binaryHandle(format, maxDepth))
+	if err := dec.Decode(&v); err != nil {
+		if strings.Contains(err.Error(), "maximum decoding depth exceeded") {
+			return Metadata{}, ErrTooDeep
+		}
+		return Metadata{}, err
+	}
+	if dec.NumBytesRead() != len(by) {
+		return Metadata{}, errors.New("metadata: trailing data after document")
+	}
+	s := shaper{maxDepth: maxDepth, maxElements: maxElements}
+	v, err := s.shape(v, 0)
+	if err != nil {
+		return Metadata{}, err
+	}
+	return NewMetadata(map[string]interface{}{"data": v}), nil
+}
+
+// shaper converts the values of binary formats to the types that
+// encoding/json decodes to, so templates see the same data regardless of
+// the format: numbers become float64 and byte strings become strings.
+// Values are counted against the limits on the way.
+type shaper struct {
+	maxDepth    int
+	maxElements int
+	elements    int
+}
+
+// shape converts v, which is nested depth levels deep.
+func (s *shaper) shape(v interface{}, depth int) (interface{}, error) {
+	if s.elements++; s.maxElements > 0 && s.elements > s.maxElements {
+		return nil, ErrTooManyElements
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, s.shapeMap(v, depth+1)
+	case map[interface{}]interface{}:
+		m := stringMap(v)
+		return m, s.shapeMap(m, depth+1)
+	case []interface{}:
+		if s.maxDepth > 0 && depth+1 > s.maxDepth {
+			return nil, ErrTooDeep
+		}
+		for i, e := range v {
+			var err error
+			if v[i], err = s.shape(e, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case []byte:
+		return string(v), nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case float32:
+		return float64(v), nil
+	}
+	return v, nil
+}
+
+// shapeMap converts the values of m, which is nested depth levels deep.
+func (s *shaper) shapeMap(m map[string]interface{}, depth int) error {
+	if s.maxDepth > 0 && depth > s.maxDepth {
+		return ErrTooDeep
+	}
+	for k, e := range m {
+		v, err := s.shape(e, depth)
+		if err != nil {
+			return err
+		}
+		m[k] = v
+	}
+	return nil
+}
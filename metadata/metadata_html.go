@@ -0,0 +1,155 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// HTMLParser is the parser for complete HTML documents. The title, meta
+// tags and head assets are pulled out of the document and the body is
+// the content of its <body> element.
+type HTMLParser struct {
+	metadata Metadata
+	body     *bytes.Buffer
+}
+
+// Type returns the kind of parser this struct is.
+func (h *HTMLParser) Type() string {
+	return "HTML"
+}
+
+// IsHTMLDocument reports whether b looks like a complete HTML document,
+// as opposed to a fragment.
+func IsHTMLDocument(b []byte) bool {
+	start := bytes.ToLower(bytes.TrimSpace(b))
+	if len(start) > 512 {
+		start = start[:512]
+	}
+	// skip comments before the doctype or root element
+	for bytes.HasPrefix(start, []byte("<!--")) {
+		end := bytes.Index(start, []byte("-->"))
+		if end < 0 {
+			return false
+		}
+		start = bytes.TrimSpace(start[end+3:])
+	}
+	return bytes.HasPrefix(start, []byte("<!doctype html")) || bytes.HasPrefix(start, []byte("<html"))
+}
+
+// Parse parses the document and prepares the metadata and body. It
+// returns false if b is not a complete HTML document.
+func (h *HTMLParser) Parse(b []byte) bool {
+	if !IsHTMLDocument(b) {
+		return false
+	}
+	doc, err := html.Parse(bytes.NewReader(b))
+	if err != nil {
+		return false
+	}
+
+	var title string
+	meta := make(map[string]interface{})
+	head := new(bytes.Buffer)
+	h.body = new(bytes.Buffer)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type != html.ElementNode {
+				continue
+			}
+			switch c.DataAtom {
+			case atom.Head:
+				walk(c)
+			case atom.Title:
+				title = strings.TrimSpace(textContent(c))
+			case atom.Meta:
+				if key, content, ok := metaTag(c); ok {
+					meta[key] = content
+				}
+			case atom.Link, atom.Script, atom.Style, atom.Base, atom.Noscript:
+				if err := html.Render(head, c); err == nil {
+					head.WriteByte('\n')
+				}
+			case atom.Body:
+				for bc := c.FirstChild; bc != nil; bc = bc.NextSibling {
+					html.Render(h.body, bc)
+				}
+			case atom.Html:
+				walk(c)
+			}
+		}
+	}
+	walk(doc)
+
+	h.metadata = NewMetadata(map[string]interface{}{
+		"meta": meta,
+		"head": head.String(),
+	})
+	h.metadata.Title = title
+	return true
+}
+
+// Metadata returns parsed metadata. It should be called
+// only after a call to Parse returns true.
+func (h *HTMLParser) Metadata() Metadata {
+	return h.metadata
+}
+
+// Body returns the content of the document's <body>. It should be called
+// only after a call to Parse returns true.
+func (h *HTMLParser) Body() []byte {
+	return h.body.Bytes()
+}
+
+// metaTag returns the key and content of a meta tag. Tags are keyed by
+// their name, property (as used by Open Graph) or http-equiv attribute.
+func metaTag(n *html.Node) (key, content string, ok bool) {
+	var hasContent bool
+	for _, a := range n.Attr {
+		switch strings.ToLower(a.Key) {
+		case "name", "http-equiv":
+			key = strings.ToLower(a.Val)
+		case "property":
+			if key == "" {
+				key = a.Val
+			}
+		case "content":
+			content, hasContent = a.Val, true
+		}
+	}
+	return key, content, key != "" && hasContent
+}
+
+// textContent returns the text inside n.
+func textContent(n *html.Node) string {
+	var b bytes.Buffer
+	var walk func(*html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(n)
+	return b.String()
+}
@@ -37,6 +37,17 @@ package metadata
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+)
+
+var (
+	// ErrTooDeep is returned by CheckJSON and the binary parsers for
+	// documents nested too deeply.
+	ErrTooDeep = errors.New("metadata: input nested too deeply")
+
+	// ErrTooManyElements is returned by CheckJSON and the binary parsers
+	// for documents with too many values.
+	ErrTooManyElements = errors.New("metadata: input has too many elements")
 )
 
 // JSONParser is the MetadataParser for JSON
@@ -126,3 +137,56 @@ func (j *JSONParser) Metadata() Metadata {
 func (j *JSONParser) Body() []byte {
 	return j.body.Bytes()
 }
+
+// CheckJSON scans a JSON document, or the JSON front matter of a document,
+// without decoding it. It returns an error if the JSON is nested deeper
+// than maxDepth or holds more than maxElements values. A limit of zero
+// is not checked. Documents that don't start with JSON are ignored.
+func CheckJSON(by []byte, maxDepth, maxElements int) error {
+	if maxDepth <= 0 && maxElements <= 0 {
+		return nil
+	}
+	by = bytes.TrimSpace(by)
+	if len(by) == 0 || (by[0] != '{' && by[0] != '[') {
+		return nil
+	}
+
+	var depth, elements int
+	var inString, escaped bool
+	for _, c := range by {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			elements++
+			if maxDepth > 0 && depth > maxDepth {
+				return ErrTooDeep
+			}
+		case '}', ']':
+			depth--
+			if depth == 0 {
+				// end of the document or its front matter
+				return nil
+			}
+		case ',':
+			elements++
+		}
+		if maxElements > 0 && elements > maxElements {
+			return ErrTooManyElements
+		}
+	}
+	return nil
+}
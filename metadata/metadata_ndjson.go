@@ -0,0 +1,93 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+)
+
+// ErrTooManyLines is returned by CheckNDJSON for documents with too many
+// lines.
+var ErrTooManyLines = errors.New("metadata: NDJSON has too many lines")
+
+// NDJSONParser is the Parser for newline-delimited JSON (JSON Lines).
+// Each line is decoded into an element of the data; the body is empty.
+type NDJSONParser struct {
+	metadata Metadata
+	body     *bytes.Buffer
+}
+
+// Type returns the kind of parser this struct is.
+func (n *NDJSONParser) Type() string {
+	return "NDJSON"
+}
+
+// Parse decodes each non-blank line of by. It returns false if any line
+// is not valid JSON.
+func (n *NDJSONParser) Parse(by []byte) bool {
+	data := []interface{}{}
+	for _, line := range ndjsonLines(by) {
+		var v interface{}
+		if err := json.Unmarshal(line, &v); err != nil {
+			return false
+		}
+		data = append(data, v)
+	}
+
+	n.body = bytes.NewBuffer(nil)
+	n.metadata = NewMetadata(map[string]interface{}{"data": data})
+	return true
+}
+
+// Metadata returns parsed metadata. It should be called
+// only after a call to Parse returns true.
+func (n *NDJSONParser) Metadata() Metadata {
+	return n.metadata
+}
+
+// Body returns the body, which is always empty. It should be called
+// only after a call to Parse returns true.
+func (n *NDJSONParser) Body() []byte {
+	return n.body.Bytes()
+}
+
+// CheckNDJSON checks an NDJSON document without decoding it. It returns
+// an error if it has more than maxLines lines or if a line breaks the
+// limits of CheckJSON. A limit of zero is not checked.
+func CheckNDJSON(by []byte, maxLines, maxDepth, maxElements int) error {
+	lines := ndjsonLines(by)
+	if maxLines > 0 && len(lines) > maxLines {
+		return ErrTooManyLines
+	}
+	for _, line := range lines {
+		if err := CheckJSON(line, maxDepth, maxElements); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ndjsonLines returns the non-blank lines of by.
+func ndjsonLines(by []byte) [][]byte {
+	var lines [][]byte
+	for _, line := range bytes.Split(by, []byte("\n")) {
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
@@ -0,0 +1,95 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrPointerNotFound is returned by Resolve for pointers to values that
+// don't exist.
+var ErrPointerNotFound = errors.New("metadata: JSON pointer not found")
+
+// ValidPointer checks the syntax of a JSON Pointer (RFC 6901).
+func ValidPointer(pointer string) error {
+	if pointer != "" && !strings.HasPrefix(pointer, "/") {
+		return fmt.Errorf("metadata: JSON pointer %q must start with /", pointer)
+	}
+	for i := 0; i < len(pointer); i++ {
+		if pointer[i] == '~' && (i+1 == len(pointer) || (pointer[i+1] != '0' && pointer[i+1] != '1')) {
+			return fmt.Errorf("metadata: JSON pointer %q has an invalid escape", pointer)
+		}
+	}
+	return nil
+}
+
+// Resolve returns the value that pointer, a JSON Pointer (RFC 6901),
+// refers to in v. The empty pointer refers to v itself.
+func Resolve(v interface{}, pointer string) (interface{}, error) {
+	if err := ValidPointer(pointer); err != nil {
+		return nil, err
+	}
+	if pointer == "" {
+		return v, nil
+	}
+
+	for _, token := range strings.Split(pointer[1:], "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch c := v.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			next, ok := stringMap(c)[token]
+			if !ok {
+				return nil, ErrPointerNotFound
+			}
+			v = next
+		case []interface{}:
+			// array indexes are plain decimal numbers without leading zeros
+			if strings.TrimLeft(token, "0123456789") != "" || (len(token) > 1 && token[0] == '0') {
+				return nil, ErrPointerNotFound
+			}
+			i, err := strconv.Atoi(token)
+			if err != nil || i >= len(c) {
+				return nil, ErrPointerNotFound
+			}
+			v = c[i]
+		default:
+			return nil, ErrPointerNotFound
+		}
+	}
+	return v, nil
+}
+
+// Reroot replaces the document data with the value that pointer refers
+// to in it. The title and template are taken from the new data, if it
+// has them.
+func (m *Metadata) Reroot(pointer string) error {
+	data, err := Resolve(m.Variables["data"], pointer)
+	if err != nil {
+		return err
+	}
+	m.Variables["data"] = data
+
+	root := NewMetadata(map[string]interface{}{"data": data})
+	if root.Title != "" {
+		m.Title = root.Title
+	}
+	if root.Template != "" {
+		m.Template = root.Template
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// DefaultNamespace is the front matter key that holds response settings
+// unless another one is configured.
+const DefaultNamespace = "stencil"
+
+// Response holds the response settings found in the reserved namespace
+// of a document's front matter.
+type Response struct {
+	// Status code of the response
+	Status int
+
+	// Value of the Cache-Control header
+	CacheControl string
+
+	// Value of the Content-Type header
+	ContentType string
+
+	// URL to redirect the client to
+	RedirectTo string
+
+	// Any other headers to set on the response
+	Headers map[string]string
+}
+
+// Response removes the reserved namespace from the document data and
+// returns the response settings found in it. It returns nil if the
+// document has no such namespace.
+func (m *Metadata) Response(namespace string) *Response {
+	data, ok := m.Variables["data"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	raw, ok := data[namespace]
+	if !ok {
+		return nil
+	}
+	settings := stringMap(raw)
+	if settings == nil {
+		return nil
+	}
+	delete(data, namespace)
+
+	resp := &Response{
+		CacheControl: toString(settings["cache_control"]),
+		ContentType:  toString(settings["content_type"]),
+		RedirectTo:   toString(settings["redirect_to"]),
+		Headers:      make(map[string]string),
+	}
+	if status, err := strconv.Atoi(toString(settings["status"])); err == nil {
+		resp.Status = status
+	}
+	for k, v := range stringMap(settings["headers"]) {
+		resp.Headers[k] = toString(v)
+	}
+
+	return resp
+}
+
+// stringMap converts the maps produced by the different parsers into a
+// map with string keys. YAML decodes nested maps with interface{} keys.
+func stringMap(v interface{}) map[string]interface{} {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m
+	case map[interface{}]interface{}:
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[fmt.Sprint(k)] = v
+		}
+		return sm
+	}
+	return nil
+}
+
+func toString(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -35,9 +35,13 @@
 package metadata
 
 import (
+	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/ugorji/go/codec"
 )
 
 func check(t *testing.T, err error) {
@@ -342,3 +346,363 @@ Mycket olika byggnader har man i de nordiska rikena: pyramidformiga, kilformiga,
 		}
 	}
 }
+
+func TestResponse(t *testing.T) {
+	inputs := []string{`---
+title: A title
+stencil:
+  status: 404
+  cache_control: no-cache
+  headers:
+    X-Robots-Tag: noindex
+---
+Page content
+`, `{
+	"title": "A title",
+	"stencil": {
+		"status": 404,
+		"cache_control": "no-cache",
+		"headers": {"X-Robots-Tag": "noindex"}
+	}
+}
+Page content
+`, `+++
+title = "A title"
+[stencil]
+status = 404
+cache_control = "no-cache"
+[stencil.headers]
+X-Robots-Tag = "noindex"
++++
+Page content
+`}
+
+	for i, input := range inputs {
+		md := GetParser([]byte(input)).Metadata()
+		resp := md.Response(DefaultNamespace)
+		if resp == nil {
+			t.Fatalf("Test %d: expected response settings, got none", i)
+		}
+		if resp.Status != 404 {
+			t.Errorf("Test %d: expected status 404, got %d", i, resp.Status)
+		}
+		if resp.CacheControl != "no-cache" {
+			t.Errorf("Test %d: expected cache_control no-cache, got %q", i, resp.CacheControl)
+		}
+		if resp.Headers["X-Robots-Tag"] != "noindex" {
+			t.Errorf("Test %d: expected X-Robots-Tag header noindex, got %q", i, resp.Headers["X-Robots-Tag"])
+		}
+
+		data := md.Variables["data"].(map[string]interface{})
+		if _, ok := data[DefaultNamespace]; ok {
+			t.Errorf("Test %d: expected namespace to be removed from data", i)
+		}
+		if data["title"] != "A title" {
+			t.Errorf("Test %d: expected title to be left in data, got %v", i, data["title"])
+		}
+	}
+}
+
+func TestCheckJSON(t *testing.T) {
+	tests := []struct {
+		input       string
+		maxDepth    int
+		maxElements int
+		expected    error
+	}{
+		{`{"a": {"b": {"c": 1}}}`, 3, 0, nil},
+		{`{"a": {"b": {"c": [1]}}}`, 3, 0, ErrTooDeep},
+		{`[1, 2, 3]`, 0, 4, nil},
+		{`[1, 2, 3, 4, 5]`, 0, 4, ErrTooManyElements},
+		{`{"text": "[[[[, , , ,]]]] \"{{{{"}`, 2, 2, nil},
+		{"{\"title\": \"Front matter\"}\n[[[[[[ body is not checked", 1, 0, nil},
+		{`<p>[[[[[[</p>`, 1, 1, nil},
+	}
+
+	for i, test := range tests {
+		if err := CheckJSON([]byte(test.input), test.maxDepth, test.maxElements); err != test.expected {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, err)
+		}
+	}
+}
+
+func TestHTMLParser(t *testing.T) {
+	doc := `<!-- legacy page -->
+<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title> Weather in London </title>
+	<meta name="Description" content="Today's forecast">
+	<meta property="og:image" content="/london.jpg">
+	<link rel="stylesheet" href="/legacy.css">
+	<script src="/legacy.js"></script>
+</head>
+<body class="home"><h1>London</h1><p>Sunny</p></body>
+</html>`
+
+	p := &HTMLParser{}
+	if !p.Parse([]byte(doc)) {
+		t.Fatal("Expected HTML document to be parsed")
+	}
+	md := p.Metadata()
+	if md.Title != "Weather in London" {
+		t.Errorf("Expected title %q, got %q", "Weather in London", md.Title)
+	}
+	meta, ok := md.Variables["meta"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("Expected meta tags, got %v", md.Variables["meta"])
+	}
+	if meta["description"] != "Today's forecast" || meta["og:image"] != "/london.jpg" || len(meta) != 2 {
+		t.Errorf("Unexpected meta tags %v", meta)
+	}
+	if head := md.Variables["head"]; head != "<link rel=\"stylesheet\" href=\"/legacy.css\"/>\n<script src=\"/legacy.js\"></script>\n" {
+		t.Errorf("Unexpected head assets %q", head)
+	}
+	if body := strings.TrimSpace(string(p.Body())); body != "<h1>London</h1><p>Sunny</p>" {
+		t.Errorf("Unexpected body %q", body)
+	}
+
+	for _, fragment := range []string{"<p>Just a fragment</p>", "Plain text", "{\"title\": \"JSON\"}"} {
+		if (&HTMLParser{}).Parse([]byte(fragment)) {
+			t.Errorf("Expected %q not to be parsed as an HTML document", fragment)
+		}
+	}
+}
+
+func TestDataDocuments(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+		title  string
+	}{
+		{"title: A title\nname: value\n", FormatYAML, "A title"},
+		{"---\ntitle: A title\n", FormatYAML, "A title"},
+		{"---\ntitle: A title\n---\nbody\n", FormatYAML, "A title"},
+		{"title = \"A title\"\nname = \"value\"\n", FormatTOML, "A title"},
+	}
+
+	for i, test := range tests {
+		p, err := GetDataParser([]byte(test.input), test.format, 0, 0)
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if p.Metadata().Title != test.title {
+			t.Errorf("Test %d: expected title %q, got %q", i, test.title, p.Metadata().Title)
+		}
+	}
+
+	p, err := GetDataParser([]byte("- one\n- two\n"), FormatYAML, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list, ok := p.Metadata().Variables["data"].([]interface{}); !ok || len(list) != 2 {
+		t.Errorf("Expected YAML list as data, got %v", p.Metadata().Variables["data"])
+	}
+	if len(p.Body()) != 0 {
+		t.Errorf("Expected empty body, got %q", p.Body())
+	}
+
+	// without a format, undelimited data is just text
+	if p, _ := GetDataParser([]byte("title: A title\n"), "", 0, 0); p.Type() != "None" {
+		t.Errorf("Expected plain text, got %s", p.Type())
+	}
+
+	formats := []struct {
+		ext, contentType, format string
+	}{
+		{".yml", "", FormatYAML},
+		{".TOML", "", FormatTOML},
+		{"", "application/x-yaml; charset=utf-8", FormatYAML},
+		{"", "application/toml", FormatTOML},
+		{".html", "text/html", ""},
+	}
+	for _, f := range formats {
+		if got := DataFormat(f.ext, f.contentType); got != f.format {
+			t.Errorf("Expected format %q for %q and %q, got %q", f.format, f.ext, f.contentType, got)
+		}
+	}
+}
+
+func TestNDJSON(t *testing.T) {
+	input := "{\"event\": \"login\", \"user\": 1}\r\n\n[1, 2]\n\"text\"\n"
+
+	p, err := GetDataParser([]byte(input), FormatNDJSON, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Type() != "NDJSON" {
+		t.Fatalf("Expected NDJSON parser, got %s", p.Type())
+	}
+	data, ok := p.Metadata().Variables["data"].([]interface{})
+	if !ok || len(data) != 3 {
+		t.Fatalf("Expected 3 lines of data, got %v", p.Metadata().Variables["data"])
+	}
+	if event, ok := data[0].(map[string]interface{}); !ok || event["event"] != "login" {
+		t.Errorf("Expected first line to be decoded, got %v", data[0])
+	}
+	if data[2] != "text" {
+		t.Errorf("Expected last line to be decoded, got %v", data[2])
+	}
+
+	// invalid documents are not taken for JSON with a body
+	if p, err := GetDataParser([]byte("{\"ok\": true}\nnot json\n"), FormatNDJSON, 0, 0); err != ErrInvalidDocument {
+		t.Errorf("Expected ErrInvalidDocument for invalid line, got %v and %v", p, err)
+	}
+
+	if err := CheckNDJSON([]byte(input), 2, 0, 0); err != ErrTooManyLines {
+		t.Errorf("Expected ErrTooManyLines, got %v", err)
+	}
+	if err := CheckNDJSON([]byte(input), 3, 0, 1); err != ErrTooManyElements {
+		t.Errorf("Expected ErrTooManyElements, got %v", err)
+	}
+	if err := CheckNDJSON([]byte(input), 3, 0, 0); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestBinaryParsers(t *testing.T) {
+	doc := map[string]interface{}{
+		"title":  "A title",
+		"count":  3,
+		"ratio":  0.5,
+		"tags":   []interface{}{"a", "b"},
+		"nested": map[string]interface{}{"ok": true},
+	}
+	jsonParser := &JSONParser{}
+	if !jsonParser.Parse([]byte(`{"title": "A title", "count": 3, "ratio": 0.5, "tags": ["a", "b"], "nested": {"ok": true}}`)) {
+		t.Fatal("Expected JSON to be parsed")
+	}
+	expected := jsonParser.Metadata().Variables["data"]
+
+	for _, test := range []struct {
+		format string
+		handle codec.Handle
+	}{
+		{FormatMsgpack, &codec.MsgpackHandle{WriteExt: true}},
+		{FormatCBOR, &codec.CborHandle{}},
+	} {
+		var b []byte
+		if err := codec.NewEncoderBytes(&b, test.handle).Encode(doc); err != nil {
+			t.Fatal(err)
+		}
+
+		p, err := GetDataParser(b, test.format, 0, 0)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", test.format, err)
+			continue
+		}
+		md := p.Metadata()
+		if md.Title != "A title" {
+			t.Errorf("%s: expected title %q, got %q", test.format, "A title", md.Title)
+		}
+		if !reflect.DeepEqual(md.Variables["data"], expected) {
+			t.Errorf("%s: expected data %#v, got %#v", test.format, expected, md.Variables["data"])
+		}
+		if len(p.Body()) != 0 {
+			t.Errorf("%s: expected empty body, got %q", test.format, p.Body())
+		}
+	}
+
+	// trailing bytes make a document invalid
+	if _, err := GetDataParser([]byte{0xc3, 0xc3}, FormatMsgpack, 0, 0); err != ErrInvalidDocument {
+		t.Errorf("Expected ErrInvalidDocument for trailing bytes, got %v", err)
+	}
+}
+
+func TestBinaryLimits(t *testing.T) {
+	doc := map[string]interface{}{
+		"items": []interface{}{
+			map[string]interface{}{"name": "a"},
+			map[string]interface{}{"name": "b"},
+		},
+	}
+	for _, test := range []struct {
+		format string
+		handle codec.Handle
+	}{
+		{FormatMsgpack, &codec.MsgpackHandle{}},
+		{FormatCBOR, &codec.CborHandle{}},
+	} {
+		var b []byte
+		if err := codec.NewEncoderBytes(&b, test.handle).Encode(doc); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := GetDataParser(b, test.format, 3, 6); err != nil {
+			t.Errorf("%s: expected no error within the limits, got %v", test.format, err)
+		}
+		if _, err := GetDataParser(b, test.format, 2, 0); err != ErrTooDeep {
+			t.Errorf("%s: expected ErrTooDeep, got %v", test.format, err)
+		}
+		if _, err := GetDataParser(b, test.format, 0, 4); err != ErrTooManyElements {
+			t.Errorf("%s: expected ErrTooManyElements, got %v", test.format, err)
+		}
+	}
+
+	// decoding a deeply nested bomb stops early
+	bomb := append(bytes.Repeat([]byte{0x81}, 100000), 0x00)
+	if _, err := GetDataParser(bomb, FormatCBOR, 10, 0); err != ErrTooDeep {
+		t.Errorf("Expected ErrTooDeep for nested CBOR arrays, got %v", err)
+	}
+}
+
+func TestPointer(t *testing.T) {
+	doc := map[string]interface{}{
+		"status": "ok",
+		"result": map[string]interface{}{
+			"title": "Results",
+			"items": []interface{}{"zero", "one"},
+			"a/b":   map[interface{}]interface{}{"m~n": 1},
+		},
+	}
+
+	tests := []struct {
+		pointer  string
+		expected interface{}
+		err      bool
+	}{
+		{"", doc, false},
+		{"/status", "ok", false},
+		{"/result/items/1", "one", false},
+		{"/result/a~1b/m~0n", 1, false},
+		{"/result/items/2", nil, true},
+		{"/result/items/01", nil, true},
+		{"/result/items/-", nil, true},
+		{"/missing", nil, true},
+		{"/status/deeper", nil, true},
+		{"status", nil, true},
+		{"/bad~2escape", nil, true},
+	}
+
+	for i, test := range tests {
+		v, err := Resolve(doc, test.pointer)
+		if test.err {
+			if err == nil {
+				t.Errorf("Test %d: expected an error for %q", i, test.pointer)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, test.expected) {
+			t.Errorf("Test %d: expected %v, got %v", i, test.expected, v)
+		}
+	}
+
+	md := NewMetadata(map[string]interface{}{"data": doc})
+	if err := md.Reroot("/result"); err != nil {
+		t.Fatal(err)
+	}
+	if md.Title != "Results" {
+		t.Errorf("Expected title from new root, got %q", md.Title)
+	}
+	if data, ok := md.Variables["data"].(map[string]interface{}); !ok || data["items"] == nil {
+		t.Errorf("Expected data to be re-rooted, got %v", md.Variables["data"])
+	}
+	if err := md.Reroot("/missing"); err != ErrPointerNotFound {
+		t.Errorf("Expected ErrPointerNotFound, got %v", err)
+	}
+}
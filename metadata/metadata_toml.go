@@ -70,6 +70,19 @@ func (t *TOMLParser) Parse(by []byte) bool {
 	return true
 }
 
+// ParseData parses the whole of by as TOML data, without delimiters or
+// a body.
+func (t *TOMLParser) ParseData(by []byte) bool {
+	m := make(map[string]interface{})
+	if err := toml.Unmarshal(by, &m); err != nil {
+		return false
+	}
+
+	t.body = bytes.NewBuffer(nil)
+	t.metadata = NewMetadata(map[string]interface{}{"data": m})
+	return true
+}
+
 // Metadata returns parsed metadata.  It should be called
 // only after a call to Parse returns without error.
 func (t *TOMLParser) Metadata() Metadata {
@@ -71,6 +71,31 @@ func (y *YAMLParser) Parse(by []byte) bool {
 	return true
 }
 
+// ParseData parses the whole of by as YAML data, without delimiters or
+// a body.
+func (y *YAMLParser) ParseData(by []byte) bool {
+	var data interface{}
+	if err := yaml.Unmarshal(by, &data); err != nil {
+		return false
+	}
+	switch v := data.(type) {
+	case nil:
+		data = make(map[string]interface{})
+	case []interface{}:
+	default:
+		// mappings are decoded with interface{} keys
+		m := stringMap(v)
+		if m == nil {
+			return false
+		}
+		data = m
+	}
+
+	y.body = bytes.NewBuffer(nil)
+	y.metadata = NewMetadata(map[string]interface{}{"data": data})
+	return true
+}
+
 // Metadata returns parsed metadata.  It should be called
 // only after a call to Parse returns without error.
 func (y *YAMLParser) Metadata() Metadata {
@@ -0,0 +1,101 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// paramPlaceholder matches the {name} placeholders of path scopes, source
+// URLs and template names.
+var paramPlaceholder = regexp.MustCompile(`\{(\w+)\}`)
+
+// paramSegment matches path scope segments that are a placeholder.
+var paramSegment = regexp.MustCompile(`^\{(\w+)\}$`)
+
+// isParam returns the name of the placeholder that makes up all of seg.
+func isParam(seg string) (string, bool) {
+	m := paramSegment.FindStringSubmatch(seg)
+	if m == nil {
+		return "", false
+	}
+	return m[1], true
+}
+
+// matchScope reports whether p is within scope and returns the values of
+// the placeholders in scope. A placeholder matches one non-empty path
+// segment; the rest of scope matches like any other Caddy base path.
+func matchScope(scope, p string) (map[string]string, bool) {
+	if !strings.Contains(scope, "{") {
+		return nil, httpserver.Path(p).Matches(scope)
+	}
+
+	scopeSegs := strings.Split(scope, "/")
+	pathSegs := strings.Split(p, "/")
+	if len(pathSegs) < len(scopeSegs) {
+		return nil, false
+	}
+
+	params := make(map[string]string)
+	last := len(scopeSegs) - 1
+	for i, seg := range scopeSegs {
+		if name, ok := isParam(seg); ok {
+			if pathSegs[i] == "" {
+				return nil, false
+			}
+			params[name] = pathSegs[i]
+			continue
+		}
+		if i == last {
+			// the last segment is a prefix, like in any base path
+			if !segmentHasPrefix(pathSegs[i], seg) {
+				return nil, false
+			}
+			continue
+		}
+		if !segmentEqual(pathSegs[i], seg) {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func segmentEqual(a, b string) bool {
+	if httpserver.CaseSensitivePath {
+		return a == b
+	}
+	return strings.EqualFold(a, b)
+}
+
+func segmentHasPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && segmentEqual(s[:len(prefix)], prefix)
+}
+
+// expandParams replaces the placeholders in s that name a parameter.
+// Other placeholders are left alone.
+func expandParams(s string, params map[string]string) string {
+	if len(params) == 0 {
+		return s
+	}
+	return paramPlaceholder.ReplaceAllStringFunc(s, func(ph string) string {
+		if v, ok := params[ph[1:len(ph)-1]]; ok {
+			return v
+		}
+		return ph
+	})
+}
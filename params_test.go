@@ -0,0 +1,91 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestMatchScope(t *testing.T) {
+	tests := []struct {
+		scope   string
+		path    string
+		matches bool
+		params  map[string]string
+	}{
+		{"/", "/anything", true, nil},
+		{"/blog", "/other", false, nil},
+		{"/api/location/{woeid}/", "/api/location/44418/", true, map[string]string{"woeid": "44418"}},
+		{"/api/location/{woeid}/", "/api/location/44418/forecast.json", true, map[string]string{"woeid": "44418"}},
+		{"/api/location/{woeid}/", "/API/Location/44418/", true, map[string]string{"woeid": "44418"}},
+		{"/api/location/{woeid}/", "/api/location//", false, nil},
+		{"/api/location/{woeid}/", "/api/location/44418", false, nil},
+		{"/api/location/{woeid}", "/api/location/44418.json", true, map[string]string{"woeid": "44418.json"}},
+		{"/{lang}/docs/{page}", "/en/docs/install", true, map[string]string{"lang": "en", "page": "install"}},
+		{"/{lang}/docs/{page}", "/en/blog/install", false, nil},
+	}
+
+	for i, test := range tests {
+		params, ok := matchScope(test.scope, test.path)
+		if ok != test.matches {
+			t.Errorf("Test %d: expected %s matching %s to be %v", i, test.path, test.scope, test.matches)
+			continue
+		}
+		if ok && !reflect.DeepEqual(params, test.params) {
+			t.Errorf("Test %d: expected params %v, got %v", i, test.params, params)
+		}
+	}
+}
+
+func TestExpandParams(t *testing.T) {
+	params := map[string]string{"woeid": "44418"}
+	if got, want := expandParams("city-{woeid}-{host}", params), "city-44418-{host}"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestParams(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(`default`))
+	template.Must(tmpl.New("city-44418").Parse(`London {{ .Params.woeid }}`))
+
+	cfg := &Config{
+		PathScope:     "/api/location/{woeid}/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      tmpl,
+		TemplateFiles: make(map[string]*CachedFileInfo),
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"template": "city-{woeid}"}`))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/api/location/44418/index.json", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if _, err := st.ServeHTTP(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "London 44418" {
+		t.Errorf("Expected page rendered with parameters, got %q", got)
+	}
+}
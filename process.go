@@ -35,9 +35,12 @@
 package stencil
 
 import (
+	"bytes"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path"
 
 	"github.com/jimjimovich/caddy-stencil/metadata"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
@@ -50,25 +53,104 @@ type FileInfo struct {
 }
 
 // Stencil processes the contents of a page in r. It parses the metadata
-// (if any) and uses the template (if found).
-func (c *Config) Stencil(title string, r io.Reader, ctx httpserver.Context) ([]byte, error) {
-	contents, err := ioutil.ReadAll(r)
-	if err != nil {
+// (if any) and uses the template (if found). The document is rendered
+// into a copy of d.
+func (c *Config) Stencil(title string, r io.Reader, d Data) ([]byte, error) {
+	b := new(bytes.Buffer)
+	if err := c.StencilTo(b, title, r, d); err != nil {
 		return nil, err
 	}
+	return b.Bytes(), nil
+}
+
+// StencilTo is like Stencil, but writes the rendered page to w as it is
+// executed.
+func (c *Config) StencilTo(w io.Writer, title string, r io.Reader, d Data) error {
+	// avoid copying contents that are already buffered
+	var contents []byte
+	if b, ok := r.(*bytes.Buffer); ok {
+		contents = b.Bytes()
+	} else {
+		var err error
+		if contents, err = ioutil.ReadAll(r); err != nil {
+			return err
+		}
+	}
 
-	parser := metadata.GetParser(contents)
+	// take legacy pages apart instead of nesting them in the template
+	var parser metadata.Parser
+	if c.ParseHTML {
+		if p := new(metadata.HTMLParser); p.Parse(contents) {
+			parser = p
+		}
+	}
+	if parser == nil {
+		var err error
+		if parser, err = metadata.GetDataParser(contents, d.dataFormat(), c.MaxDepth, c.MaxElements); err != nil {
+			return err
+		}
+	}
 	body := parser.Body()
 	mdata := parser.Metadata()
 
+	// pull the response settings out of the document data
+	if c.Namespace != "" && d.intent != nil {
+		if resp := mdata.Response(c.Namespace); resp != nil {
+			if err := d.intent.loadFrontMatter(resp); err != nil {
+				return err
+			}
+		}
+	}
+
+	// unwrap the payload of API envelopes
+	if c.DataRoot != "" {
+		if err := mdata.Reroot(c.DataRoot); err != nil {
+			return fmt.Errorf("stencil: data_root %s: %v", c.DataRoot, err)
+		}
+	}
+
 	// set it as body for template
 	mdata.Variables["body"] = string(body)
 
+	// pick the configured parts out of the body
+	if len(c.Extracts) > 0 {
+		fragments, err := extractFragments(body, c.Extracts)
+		if err != nil {
+			return err
+		}
+		mdata.Variables["fragments"] = fragments
+	}
+
 	// fixup title
 	mdata.Variables["title"] = mdata.Title
 	if mdata.Variables["title"] == "" {
 		mdata.Variables["title"] = title
 	}
 
-	return execTemplate(c, mdata, ctx)
+	return execTemplate(c, mdata, d, w)
+}
+
+// dataFormat returns the data format that the document being rendered is
+// in as a whole, going by its extension or upstream Content-Type.
+func (d Data) dataFormat() string {
+	var ext, contentType string
+	if d.URL != nil {
+		ext = path.Ext(d.URL.Path)
+	}
+	if d.Upstream != nil {
+		contentType = d.Upstream.Header.Get("Content-Type")
+	}
+	return metadata.DataFormat(ext, contentType)
+}
+
+// Pointer returns the value that pointer, a JSON Pointer, refers to in
+// data, or nil if there is none. For example:
+//
+//	{{ .Pointer .Doc.data "/result/items/0/name" }}
+func (d Data) Pointer(data interface{}, pointer string) (interface{}, error) {
+	v, err := metadata.Resolve(data, pointer)
+	if err == metadata.ErrPointerNotFound {
+		return nil, nil
+	}
+	return v, err
 }
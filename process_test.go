@@ -0,0 +1,74 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestDataRoot(t *testing.T) {
+	const doc = `{"status": "ok", "result": {"title": "Results", "items": [{"name": "first"}]}}`
+
+	cfg := &Config{
+		Template:      template.Must(template.New("").Parse(`{{ .Doc.title }}: {{ .Pointer .Doc.data "/items/0/name" }}{{ with .Pointer .Doc.data "/missing" }}!{{ end }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		DataRoot:      "/result",
+	}
+	out, err := cfg.Stencil("page", bytes.NewBufferString(doc), Data{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "Results: first"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+
+	cfg.DataRoot = "/nothing"
+	if _, err := cfg.Stencil("page", bytes.NewBufferString(doc), Data{}); err == nil {
+		t.Error("Expected missing data root to fail")
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	const doc = `{"title": "Moved", "stencil": {"status": 1000, "redirect_to": "/elsewhere"}}`
+
+	cfg := &Config{
+		Template:      template.Must(template.New("").Parse(`{{ .Doc.data.stencil.redirect_to }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		Namespace:     "stencil",
+	}
+	if _, err := cfg.Stencil("page", bytes.NewBufferString(doc), Data{intent: newResponseIntent()}); err == nil {
+		t.Error("Expected invalid status in front matter to fail")
+	}
+	cookie := `{"stencil": {"headers": {"set-cookie": "session=1"}}}`
+	if _, err := cfg.Stencil("page", bytes.NewBufferString(cookie), Data{intent: newResponseIntent()}); err == nil {
+		t.Error("Expected Set-Cookie in front matter to fail")
+	}
+
+	cfg.Namespace = ""
+	d := Data{intent: newResponseIntent()}
+	out, err := cfg.Stencil("page", bytes.NewBufferString(doc), d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(out)), "/elsewhere"; got != want {
+		t.Errorf("Expected namespace to be left in the data, got %q", got)
+	}
+	if d.intent.status != 0 || d.intent.redirect != "" {
+		t.Errorf("Expected response to be left alone, got status %d and redirect %q", d.intent.status, d.intent.redirect)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jimjimovich/caddy-stencil/metadata"
+)
+
+// responseIntent records the changes a template asked to make to the
+// HTTP response. Intents are only applied by ServeHTTP after the
+// template rendered successfully.
+type responseIntent struct {
+	status   int
+	header   http.Header
+	redirect string
+
+	// name and modification time of the template used for rendering
+	template string
+	modTime  time.Time
+}
+
+func newResponseIntent() *responseIntent {
+	return &responseIntent{header: make(http.Header)}
+}
+
+// applyHeader copies the headers set by the template into h.
+func (ri *responseIntent) applyHeader(h http.Header) {
+	for k, v := range ri.header {
+		h[k] = v
+	}
+}
+
+// reservedHeaders can't be set by documents, because they would let an
+// upstream set cookies or break the framing of the response.
+var reservedHeaders = map[string]struct{}{
+	"Set-Cookie":          {},
+	"Content-Length":      {},
+	"Transfer-Encoding":   {},
+	"Connection":          {},
+	"Keep-Alive":          {},
+	"Proxy-Authenticate":  {},
+	"Proxy-Authorization": {},
+	"Proxy-Connection":    {},
+	"Te":                  {},
+	"Trailer":             {},
+	"Upgrade":             {},
+}
+
+// loadFrontMatter records the response settings found in the front
+// matter of a document. The template may still override them.
+func (ri *responseIntent) loadFrontMatter(resp *metadata.Response) error {
+	if resp.Status != 0 {
+		if !validStatus(resp.Status) {
+			return fmt.Errorf("stencil: invalid status code %d in front matter", resp.Status)
+		}
+		ri.status = resp.Status
+	}
+	if resp.CacheControl != "" {
+		ri.header.Set("Cache-Control", resp.CacheControl)
+	}
+	if resp.ContentType != "" {
+		ri.header.Set("Content-Type", resp.ContentType)
+	}
+	for k, v := range resp.Headers {
+		if _, ok := reservedHeaders[http.CanonicalHeaderKey(k)]; ok {
+			return fmt.Errorf("stencil: header %s can't be set from front matter", k)
+		}
+		ri.header.Set(k, v)
+	}
+	if resp.RedirectTo != "" {
+		ri.redirect = resp.RedirectTo
+	}
+	return nil
+}
+
+// validStatus reports whether code may be set as the status of a
+// rendered page.
+func validStatus(code int) bool {
+	return code >= 200 && code <= 599
+}
+
+// bodyAllowed reports whether a response with the status code may have a
+// body.
+func bodyAllowed(code int) bool {
+	return code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// redirectStatus returns the status code to redirect with, falling
+// back to 302 Found if no redirect status was set.
+func (ri *responseIntent) redirectStatus() int {
+	if ri.status < 300 || ri.status > 399 {
+		return http.StatusFound
+	}
+	return ri.status
+}
+
+// page is a rendered response that can be served more than once.
+type page struct {
+	// status to send instead of letting ServeContent choose one
+	status   int
+	header   http.Header
+	body     []byte
+	redirect string
+	modTime  time.Time
+
+	// name of the template the page was rendered with
+	template string
+}
+
+// newPage builds a page from the rendered output, the buffered upstream
+// header and the response changes requested by the document.
+func newPage(header http.Header, intent *responseIntent, body []byte, upstreamStatus int) *page {
+	header.Set("Content-Type", "text/html; charset=utf-8")
+	intent.applyHeader(header)
+
+	p := &page{
+		status:   intent.status,
+		header:   header,
+		body:     body,
+		redirect: intent.redirect,
+		template: intent.template,
+	}
+
+	// the document asked for a redirect instead of the rendered page
+	if p.redirect != "" {
+		p.status = intent.redirectStatus()
+		header.Del("Content-Length")
+		dropUpstreamValidators(header, intent)
+		return p
+	}
+
+	// ServeContent always answers with 200, so keep other upstream statuses
+	if p.status == 0 && upstreamStatus != 0 && upstreamStatus != http.StatusOK {
+		p.status = upstreamStatus
+	}
+	if p.status != 0 {
+		dropUpstreamValidators(header, intent)
+		return p
+	}
+
+	// validators must change whenever the content or the template does
+	if intent.header.Get("ETag") == "" {
+		header.Set("ETag", etag(body))
+	}
+	p.modTime, _ = time.Parse(http.TimeFormat, header.Get("Last-Modified"))
+	if intent.modTime.After(p.modTime) {
+		p.modTime = intent.modTime
+	}
+
+	return p
+}
+
+// dropUpstreamValidators removes the validators of the upstream content
+// from header, keeping those set by the document.
+func dropUpstreamValidators(header http.Header, intent *responseIntent) {
+	if intent.header.Get("ETag") == "" {
+		header.Del("ETag")
+	}
+	if intent.header.Get("Last-Modified") == "" {
+		header.Del("Last-Modified")
+	}
+}
+
+// serve writes the page to w.
+func (p *page) serve(w http.ResponseWriter, r *http.Request) {
+	for k, v := range p.header {
+		w.Header()[k] = v
+	}
+
+	switch {
+	case p.redirect != "":
+		http.Redirect(w, r, p.redirect, p.status)
+	case !bodyAllowed(p.status):
+		w.Header().Del("Content-Length")
+		w.WriteHeader(p.status)
+	case p.status != 0:
+		w.Header().Set("Content-Length", strconv.Itoa(len(p.body)))
+		w.WriteHeader(p.status)
+		if r.Method != http.MethodHead {
+			w.Write(p.body)
+		}
+	default:
+		http.ServeContent(w, r, r.URL.Path, p.modTime, bytes.NewReader(p.body))
+	}
+}
+
+// SetStatus sets the status code of the rendered response. The code
+// must be between 200 and 599.
+func (d Data) SetStatus(code int) (string, error) {
+	if !validStatus(code) {
+		return "", fmt.Errorf("stencil: invalid status code %d", code)
+	}
+	if d.intent == nil {
+		return "", nil
+	}
+	d.intent.status = code
+	return "", nil
+}
+
+// SetHeader sets a header on the rendered response, replacing any
+// existing values.
+func (d Data) SetHeader(name, value string) string {
+	if d.intent == nil {
+		return ""
+	}
+	d.intent.header.Set(name, value)
+	return ""
+}
+
+// AddHeader adds a value to a header on the rendered response.
+func (d Data) AddHeader(name, value string) string {
+	if d.intent == nil {
+		return ""
+	}
+	d.intent.header.Add(name, value)
+	return ""
+}
+
+// Redirect redirects the client to url instead of sending the rendered
+// page. The status code defaults to 302 Found.
+func (d Data) Redirect(url string, code ...int) (string, error) {
+	if len(code) > 0 && !validStatus(code[0]) {
+		return "", fmt.Errorf("stencil: invalid status code %d", code[0])
+	}
+	if d.intent == nil {
+		return "", nil
+	}
+	d.intent.redirect = url
+	if len(code) > 0 {
+		d.intent.status = code[0]
+	}
+	return "", nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSetStatus(t *testing.T) {
+	tests := []struct {
+		code    int
+		isError bool
+	}{
+		{200, false},
+		{404, false},
+		{599, false},
+		{42, true},
+		{101, true},
+		{1000, true},
+	}
+
+	for i, test := range tests {
+		d := Data{intent: newResponseIntent()}
+		_, err := d.SetStatus(test.code)
+		if test.isError {
+			if err == nil {
+				t.Errorf("Test %d: expected error for status %d", i, test.code)
+			}
+			if d.intent.status != 0 {
+				t.Errorf("Test %d: expected status not to be recorded, got %d", i, d.intent.status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+		}
+		if d.intent.status != test.code {
+			t.Errorf("Test %d: expected status %d, got %d", i, test.code, d.intent.status)
+		}
+	}
+
+	d := Data{intent: newResponseIntent()}
+	if _, err := d.Redirect("/elsewhere", 1000); err == nil {
+		t.Error("Expected error for redirect with status 1000")
+	}
+	if d.intent.redirect != "" {
+		t.Errorf("Expected redirect not to be recorded, got %q", d.intent.redirect)
+	}
+}
+
+func TestServeWithoutBody(t *testing.T) {
+	for _, code := range []int{http.StatusNoContent, http.StatusNotModified} {
+		p := &page{status: code, header: make(http.Header), body: []byte("rendered")}
+		rec := httptest.NewRecorder()
+		p.serve(rec, httptest.NewRequest("GET", "/page.json", nil))
+		if rec.Code != code {
+			t.Errorf("Expected status %d, got %d", code, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("Status %d: expected no body, got %q", code, rec.Body.String())
+		}
+	}
+}
+
+func TestNewPageValidators(t *testing.T) {
+	upstream := func() http.Header {
+		return http.Header{
+			"Etag":          {`"raw"`},
+			"Last-Modified": {"Mon, 02 Jan 2006 15:04:05 GMT"},
+		}
+	}
+
+	intent := newResponseIntent()
+	intent.status = http.StatusNotFound
+	p := newPage(upstream(), intent, []byte("rendered"), http.StatusOK)
+	if v := p.header.Get("ETag"); v != "" {
+		t.Errorf("Expected upstream ETag to be dropped, got %q", v)
+	}
+	if v := p.header.Get("Last-Modified"); v != "" {
+		t.Errorf("Expected upstream Last-Modified to be dropped, got %q", v)
+	}
+
+	intent = newResponseIntent()
+	intent.header.Set("ETag", `"mine"`)
+	p = newPage(upstream(), intent, []byte("rendered"), http.StatusGone)
+	if v := p.header.Get("ETag"); v != `"mine"` {
+		t.Errorf("Expected ETag set by the document to be kept, got %q", v)
+	}
+
+	p = newPage(upstream(), newResponseIntent(), []byte("rendered"), http.StatusOK)
+	if v := p.header.Get("ETag"); v != etag([]byte("rendered")) {
+		t.Errorf("Expected ETag of the rendered page, got %q", v)
+	}
+}
@@ -18,11 +18,17 @@ import (
 	"bytes"
 	"mime"
 	"net/http"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/jimjimovich/caddy-stencil/metadata"
 	"github.com/mholt/caddy"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
+	"golang.org/x/net/html/charset"
 )
 
 func init() {
@@ -41,8 +47,15 @@ func Setup(c *caddy.Controller) error {
 
 	cfg := httpserver.GetConfig(c)
 
-	// Add json mime type in case it is not available on the system
+	// Add data mime types in case they are not available on the system
 	mime.AddExtensionType(".json", "application/json")
+	mime.AddExtensionType(".yaml", "application/x-yaml")
+	mime.AddExtensionType(".yml", "application/x-yaml")
+	mime.AddExtensionType(".toml", "application/toml")
+	mime.AddExtensionType(".ndjson", "application/x-ndjson")
+	mime.AddExtensionType(".jsonl", "application/x-ndjson")
+	mime.AddExtensionType(".msgpack", "application/msgpack")
+	mime.AddExtensionType(".cbor", "application/cbor")
 
 	st := Stencil{
 		Root:    cfg.Root,
@@ -55,6 +68,29 @@ func Setup(c *caddy.Controller) error {
 		},
 	}
 
+	// Keep one cache big enough for every configuration that uses it
+	var cacheSize int
+	for _, stc := range stconfigs {
+		if stc.CacheTTL > 0 && stc.CacheSize > cacheSize {
+			cacheSize = stc.CacheSize
+		}
+	}
+	if cacheSize > 0 {
+		st.cache = newRenderCache(cacheSize)
+	}
+
+	for _, stc := range stconfigs {
+		if stc.MaxRenders > 0 {
+			stc.limiter = newRenderLimiter(stc.MaxRenders, stc.RenderQueue, stc.RenderWait)
+		}
+		if stc.Coalesce && st.flights == nil {
+			st.flights = newFlightGroup()
+		}
+		if stc.FallbackStale > 0 && st.fallback == nil {
+			st.fallback = newFallbackStore(defaultFallbackSize)
+		}
+	}
+
 	cfg.AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
 		st.Next = next
 		return st
@@ -71,6 +107,10 @@ func stencilParse(c *caddy.Controller) ([]*Config, error) {
 			Extensions:    make(map[string]struct{}),
 			Template:      GetDefaultTemplate(),
 			TemplateFiles: make(map[string]*CachedFileInfo),
+			LimitStatus:   http.StatusBadGateway,
+			FetchLimit:    defaultFetchLimit,
+			FetchDepth:    defaultFetchDepth,
+			MaxLines:      defaultMaxLines,
 		}
 
 		// Get the path scope
@@ -112,6 +152,317 @@ func loadParams(c *caddy.Controller, stc *Config) error {
 			stc.Extensions[ext] = struct{}{}
 		}
 		return nil
+	case "namespace":
+		args := c.RemainingArgs()
+		switch len(args) {
+		case 0:
+			stc.Namespace = metadata.DefaultNamespace
+		case 1:
+			stc.Namespace = args[0]
+			if stc.Namespace == "off" {
+				stc.Namespace = ""
+			}
+		default:
+			return c.ArgErr()
+		}
+		return nil
+	case "cache":
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		ttl, err := time.ParseDuration(args[0])
+		if err != nil {
+			return c.Errf("invalid cache ttl: %v", err)
+		}
+		stc.CacheTTL = ttl
+		stc.CacheSize = defaultCacheSize
+		if len(args) == 2 {
+			size, err := strconv.Atoi(args[1])
+			if err != nil || size < 1 {
+				return c.Errf("invalid cache size: %s", args[1])
+			}
+			stc.CacheSize = size
+		}
+		return nil
+	case "stale_while_revalidate", "stale_if_error":
+		name := c.Val()
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		d, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid %s duration: %v", name, err)
+		}
+		if name == "stale_while_revalidate" {
+			stc.StaleWhileRevalidate = d
+		} else {
+			stc.StaleIfError = d
+		}
+		return nil
+	case "coalesce":
+		stc.Coalesce = true
+		for _, name := range c.RemainingArgs() {
+			stc.CoalesceHeaders = append(stc.CoalesceHeaders, http.CanonicalHeaderKey(name))
+		}
+		return nil
+	case "fallback":
+		args := c.RemainingArgs()
+		if len(args) < 2 || len(args) > 3 {
+			return c.ArgErr()
+		}
+		if args[0] != "stale" {
+			return c.Errf("unknown fallback mode: %s", args[0])
+		}
+		maxStale, err := time.ParseDuration(args[1])
+		if err != nil {
+			return c.Errf("invalid fallback staleness: %v", err)
+		}
+		stc.FallbackStale = maxStale
+		if len(args) == 3 {
+			if err := os.MkdirAll(args[2], 0700); err != nil {
+				return c.Errf("fallback directory: %v", err)
+			}
+			stc.FallbackDir = args[2]
+		}
+		return nil
+	case "admin":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		if args[1] == "" {
+			return c.Err("admin secret must not be empty")
+		}
+		stc.AdminPath = args[0]
+		stc.AdminSecret = args[1]
+		return nil
+	case "fetch":
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 2 {
+			return c.ArgErr()
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 0 {
+			return c.Errf("invalid fetch limit: %s", args[0])
+		}
+		stc.FetchLimit = limit
+		if len(args) == 2 {
+			depth, err := strconv.Atoi(args[1])
+			if err != nil || depth < 1 {
+				return c.Errf("invalid fetch depth: %s", args[1])
+			}
+			stc.FetchDepth = depth
+		}
+		return nil
+	case "fetch_headers":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		for _, name := range args {
+			stc.FetchHeaders = append(stc.FetchHeaders, http.CanonicalHeaderKey(name))
+		}
+		return nil
+	case "source":
+		args := c.RemainingArgs()
+		if len(args) < 2 || len(args) > 4 {
+			return c.ArgErr()
+		}
+		src := Source{Name: args[0], URL: args[1], Timeout: defaultSourceTimeout}
+		for _, arg := range args[2:] {
+			if arg == "optional" {
+				src.Optional = true
+				continue
+			}
+			timeout, err := time.ParseDuration(arg)
+			if err != nil || timeout <= 0 {
+				return c.Errf("invalid source timeout: %s", arg)
+			}
+			src.Timeout = timeout
+		}
+		for _, other := range stc.Sources {
+			if other.Name == src.Name {
+				return c.Errf("duplicate source: %s", src.Name)
+			}
+		}
+		stc.Sources = append(stc.Sources, src)
+		return nil
+	case "parse_html":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		stc.ParseHTML = true
+		return nil
+	case "data_root":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		if err := metadata.ValidPointer(c.Val()); err != nil {
+			return c.Err(err.Error())
+		}
+		stc.DataRoot = c.Val()
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		return nil
+	case "extract":
+		args := c.RemainingArgs()
+		if len(args) < 2 {
+			return c.ArgErr()
+		}
+		for _, e := range stc.Extracts {
+			if e.Name == args[0] {
+				return c.Errf("duplicate extract: %s", args[0])
+			}
+		}
+		e, err := NewExtract(args[0], strings.Join(args[1:], " "))
+		if err != nil {
+			return c.Errf("invalid extract selector: %v", err)
+		}
+		stc.Extracts = append(stc.Extracts, e)
+		return nil
+	case "input_charset":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		if e, _ := charset.Lookup(c.Val()); e == nil {
+			return c.Errf("unknown input_charset: %s", c.Val())
+		}
+		stc.InputCharset = c.Val()
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		return nil
+	case "methods":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		stc.Methods = make(map[string]struct{})
+		for _, m := range args {
+			stc.Methods[strings.ToUpper(m)] = struct{}{}
+		}
+		return nil
+	case "upstream_accept":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		stc.UpstreamAccept = c.Val()
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		return nil
+	case "upstream_strip":
+		args := c.RemainingArgs()
+		if len(args) == 0 {
+			return c.ArgErr()
+		}
+		for _, name := range args {
+			stc.UpstreamStrip = append(stc.UpstreamStrip, http.CanonicalHeaderKey(name))
+		}
+		return nil
+	case "upstream_header":
+		args := c.RemainingArgs()
+		if len(args) != 2 {
+			return c.ArgErr()
+		}
+		if stc.UpstreamHeaders == nil {
+			stc.UpstreamHeaders = make(http.Header)
+		}
+		stc.UpstreamHeaders.Add(args[0], args[1])
+		return nil
+	case "stream":
+		if c.NextArg() {
+			return c.ArgErr()
+		}
+		stc.Stream = true
+		return nil
+	case "max_body":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		size, err := parseSize(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		stc.MaxBody = size
+		return nil
+	case "max_output":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		size, err := parseSize(c.Val())
+		if err != nil {
+			return c.Err(err.Error())
+		}
+		stc.MaxOutput = size
+		return nil
+	case "render_timeout":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		timeout, err := time.ParseDuration(c.Val())
+		if err != nil {
+			return c.Errf("invalid render_timeout: %v", err)
+		}
+		stc.RenderTimeout = timeout
+		return nil
+	case "max_depth", "max_elements", "max_lines":
+		name := c.Val()
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		n, err := strconv.Atoi(c.Val())
+		if err != nil || n < 1 {
+			return c.Errf("invalid %s: %s", name, c.Val())
+		}
+		switch name {
+		case "max_depth":
+			stc.MaxDepth = n
+		case "max_elements":
+			stc.MaxElements = n
+		default:
+			stc.MaxLines = n
+		}
+		return nil
+	case "limit_status":
+		if !c.NextArg() {
+			return c.ArgErr()
+		}
+		code, err := strconv.Atoi(c.Val())
+		if err != nil || code < 400 || code > 599 {
+			return c.Errf("invalid limit_status: %s", c.Val())
+		}
+		stc.LimitStatus = code
+		return nil
+	case "max_renders":
+		args := c.RemainingArgs()
+		if len(args) < 1 || len(args) > 3 {
+			return c.ArgErr()
+		}
+		limit, err := strconv.Atoi(args[0])
+		if err != nil || limit < 1 {
+			return c.Errf("invalid max_renders: %s", args[0])
+		}
+		stc.MaxRenders = limit
+		stc.RenderQueue = limit
+		stc.RenderWait = defaultRenderWait
+		if len(args) > 1 {
+			queue, err := strconv.Atoi(args[1])
+			if err != nil || queue < 0 {
+				return c.Errf("invalid max_renders queue depth: %s", args[1])
+			}
+			stc.RenderQueue = queue
+		}
+		if len(args) > 2 {
+			wait, err := time.ParseDuration(args[2])
+			if err != nil {
+				return c.Errf("invalid max_renders wait timeout: %v", err)
+			}
+			stc.RenderWait = wait
+		}
+		return nil
 	case "template":
 		tArgs := c.RemainingArgs()
 		switch len(tArgs) {
@@ -18,8 +18,11 @@
 package stencil_test
 
 import (
+	"net/http"
+	"reflect"
 	"testing"
 	"text/template"
+	"time"
 
 	"github.com/jimjimovich/caddy-stencil"
 	"github.com/mholt/caddy"
@@ -77,6 +80,99 @@ func TestSetup(t *testing.T) {
 						"test": &stencil.CachedFileInfo{"testdata/index.html", nil},
 					},
 				}}},
+		// Config with a custom front matter namespace
+		{
+			`stencil / {
+				namespace page
+			}`,
+			false,
+			[]stencil.Config{{
+				PathScope: "/",
+				Extensions: map[string]struct{}{
+					".html": {},
+					".json": {},
+				},
+				Template:      stencil.GetDefaultTemplate(),
+				TemplateFiles: make(map[string]*stencil.CachedFileInfo),
+				Namespace:     "page",
+			}}},
+		// Config with the default front matter namespace
+		{
+			`stencil / {
+				namespace
+			}`,
+			false,
+			[]stencil.Config{{
+				PathScope: "/",
+				Extensions: map[string]struct{}{
+					".html": {},
+					".json": {},
+				},
+				Template:      stencil.GetDefaultTemplate(),
+				TemplateFiles: make(map[string]*stencil.CachedFileInfo),
+				Namespace:     "stencil",
+			}}},
+		// Config with caching
+		{
+			`stencil / {
+				cache 5m 100
+				stale_while_revalidate 1m
+				stale_if_error 1h
+			}`,
+			false,
+			[]stencil.Config{{
+				PathScope: "/",
+				Extensions: map[string]struct{}{
+					".html": {},
+					".json": {},
+				},
+				Template:             stencil.GetDefaultTemplate(),
+				TemplateFiles:        make(map[string]*stencil.CachedFileInfo),
+				CacheTTL:             5 * time.Minute,
+				CacheSize:            100,
+				StaleWhileRevalidate: time.Minute,
+				StaleIfError:         time.Hour,
+			}}},
+		// Config with upstream request rewriting
+		{
+			`stencil / {
+				upstream_accept application/json
+				upstream_strip cookie
+				upstream_header X-Api-Key secret
+			}`,
+			false,
+			[]stencil.Config{{
+				PathScope: "/",
+				Extensions: map[string]struct{}{
+					".html": {},
+					".json": {},
+				},
+				Template:        stencil.GetDefaultTemplate(),
+				TemplateFiles:   make(map[string]*stencil.CachedFileInfo),
+				UpstreamAccept:  "application/json",
+				UpstreamStrip:   []string{"Cookie"},
+				UpstreamHeaders: http.Header{"X-Api-Key": {"secret"}},
+			}}},
+		// Config with sources
+		{
+			`stencil / {
+				source weather /api/location/{id}/
+				source alerts /api/alerts?city={query.id} 500ms optional
+			}`,
+			false,
+			[]stencil.Config{{
+				PathScope: "/",
+				Extensions: map[string]struct{}{
+					".html": {},
+					".json": {},
+				},
+				Template:      stencil.GetDefaultTemplate(),
+				TemplateFiles: make(map[string]*stencil.CachedFileInfo),
+				Sources: []stencil.Source{
+					{Name: "weather", URL: "/api/location/{id}/", Timeout: 10 * time.Second},
+					{Name: "alerts", URL: "/api/alerts?city={query.id}", Timeout: 500 * time.Millisecond, Optional: true},
+				},
+			}}},
 	}
 
 	for i, test := range tests {
@@ -107,6 +203,47 @@ func TestSetup(t *testing.T) {
 				t.Errorf("Expected %v PathScope, but got %v", test.expectedConfig[j].PathScope, singleConfig.PathScope)
 			}
 
+			// Test front matter namespace
+			if singleConfig.Namespace != test.expectedConfig[j].Namespace {
+				t.Errorf("Expected %v Namespace, but got %v", test.expectedConfig[j].Namespace, singleConfig.Namespace)
+			}
+
+			// Test cache settings
+			if singleConfig.CacheTTL != test.expectedConfig[j].CacheTTL {
+				t.Errorf("Expected %v CacheTTL, but got %v", test.expectedConfig[j].CacheTTL, singleConfig.CacheTTL)
+			}
+			if singleConfig.CacheSize != test.expectedConfig[j].CacheSize {
+				t.Errorf("Expected %v CacheSize, but got %v", test.expectedConfig[j].CacheSize, singleConfig.CacheSize)
+			}
+			if singleConfig.StaleWhileRevalidate != test.expectedConfig[j].StaleWhileRevalidate {
+				t.Errorf("Expected %v StaleWhileRevalidate, but got %v", test.expectedConfig[j].StaleWhileRevalidate, singleConfig.StaleWhileRevalidate)
+			}
+			if singleConfig.StaleIfError != test.expectedConfig[j].StaleIfError {
+				t.Errorf("Expected %v StaleIfError, but got %v", test.expectedConfig[j].StaleIfError, singleConfig.StaleIfError)
+			}
+
+			// Test upstream request rewriting
+			if singleConfig.UpstreamAccept != test.expectedConfig[j].UpstreamAccept {
+				t.Errorf("Expected %v UpstreamAccept, but got %v", test.expectedConfig[j].UpstreamAccept, singleConfig.UpstreamAccept)
+			}
+			if !reflect.DeepEqual(singleConfig.UpstreamStrip, test.expectedConfig[j].UpstreamStrip) {
+				t.Errorf("Expected %v UpstreamStrip, but got %v", test.expectedConfig[j].UpstreamStrip, singleConfig.UpstreamStrip)
+			}
+			if !reflect.DeepEqual(singleConfig.UpstreamHeaders, test.expectedConfig[j].UpstreamHeaders) {
+				t.Errorf("Expected %v UpstreamHeaders, but got %v", test.expectedConfig[j].UpstreamHeaders, singleConfig.UpstreamHeaders)
+			}
+
+			// Test sources
+			if len(singleConfig.Sources) != len(test.expectedConfig[j].Sources) {
+				t.Errorf("Expected %v Sources, but got %v", len(test.expectedConfig[j].Sources), len(singleConfig.Sources))
+			} else {
+				for k, src := range test.expectedConfig[j].Sources {
+					if singleConfig.Sources[k] != src {
+						t.Errorf("Expected source %+v, but got %+v", src, singleConfig.Sources[k])
+					}
+				}
+			}
+
 			// Test extensions
 			if len(test.expectedConfig[j].Extensions) != len(singleConfig.Extensions) {
 				t.Errorf("Expected %v extensions, got: %v", len(test.expectedConfig[j].Extensions), len(singleConfig.Extensions))
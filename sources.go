@@ -0,0 +1,203 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mholt/caddy/caddyhttp/httpserver"
+)
+
+// defaultSourceTimeout is how long a source may take if no timeout is
+// configured.
+const defaultSourceTimeout = 10 * time.Second
+
+// Source is a named piece of data that is fetched for every render and
+// made available to templates as .Sources.<Name>.
+type Source struct {
+	// Name the data is available under
+	Name string
+
+	// URL to fetch, which may contain placeholders
+	URL string
+
+	// How long the fetch may take
+	Timeout time.Duration
+
+	// Whether the page is rendered without the data if the fetch fails
+	Optional bool
+}
+
+// sourcePlaceholder matches the placeholders of source URLs that
+// Stencil fills in itself: {name} for path parameters and {query.name}
+// for query parameters.
+var sourcePlaceholder = regexp.MustCompile(`\{(query\.)?(\w+)\}`)
+
+// expandSource fills in the placeholders of u. Those that name a path
+// parameter, or a query parameter of r with {query.name}, get its value,
+// escaped for the part of the URL they are in. The others are left to
+// the Caddy replacer, which runs before the values are substituted so
+// that they can't bring in placeholders of their own.
+func expandSource(u string, r *http.Request, params map[string]string) (string, error) {
+	query := r.URL.Query()
+	repl := httpserver.NewReplacer(r, nil, "")
+	queryStart := queryIndex(u)
+
+	var b strings.Builder
+	var last int
+	for _, m := range sourcePlaceholder.FindAllStringSubmatchIndex(u, -1) {
+		name := u[m[4]:m[5]]
+		var v string
+		if m[2] >= 0 {
+			// missing query parameters are empty
+			v = query.Get(name)
+		} else {
+			var ok bool
+			if v, ok = params[name]; !ok {
+				continue
+			}
+		}
+
+		b.WriteString(repl.Replace(u[last:m[0]]))
+		if m[0] > queryStart {
+			b.WriteString(url.QueryEscape(v))
+		} else if v == "." || v == ".." {
+			return "", fmt.Errorf("invalid value for %s: %s", u[m[0]:m[1]], v)
+		} else {
+			b.WriteString(url.PathEscape(v))
+		}
+		last = m[1]
+	}
+	b.WriteString(repl.Replace(u[last:]))
+	return b.String(), nil
+}
+
+// queryIndex returns the index of the ? that starts the query of u, or
+// the length of u if there is none. Question marks in placeholders, like
+// that of {?name}, don't count.
+func queryIndex(u string) int {
+	var depth int
+	for i, c := range u {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '?':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(u)
+}
+
+// sourceResult is the outcome of fetching a source.
+type sourceResult struct {
+	data interface{}
+	err  error
+}
+
+// sourceFetch is a source that is being fetched.
+type sourceFetch struct {
+	source Source
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan sourceResult
+}
+
+// fetchSources starts fetching the sources of cfg for r concurrently.
+// The requests are built before fetchSources returns, so r may be
+// modified afterwards.
+func fetchSources(next httpserver.Handler, r *http.Request, cfg *Config, params map[string]string) []*sourceFetch {
+	if len(cfg.Sources) == 0 {
+		return nil
+	}
+	depth := fetchDepth(r)
+
+	fetches := make([]*sourceFetch, 0, len(cfg.Sources))
+	for _, src := range cfg.Sources {
+		f := &sourceFetch{source: src, done: make(chan sourceResult, 1)}
+		fetches = append(fetches, f)
+
+		var sub *http.Request
+		target, err := expandSource(src.URL, r, params)
+		if err == nil && depth >= cfg.FetchDepth {
+			err = fmt.Errorf("nested deeper than %d", cfg.FetchDepth)
+		}
+		if err == nil {
+			sub, err = newSubrequest(r.Context(), r, cfg, target, depth+1)
+		}
+		if err != nil {
+			f.ctx, f.cancel = context.WithCancel(r.Context())
+			f.done <- sourceResult{err: err}
+			continue
+		}
+
+		f.ctx, f.cancel = context.WithTimeout(sub.Context(), src.Timeout)
+		sub = sub.WithContext(f.ctx)
+		go func() {
+			data, err := doSubrequest(next, sub, cfg)
+			f.done <- sourceResult{data: data, err: err}
+		}()
+	}
+	return fetches
+}
+
+// waitSources waits for fetches to finish and returns their data by
+// name. Optional sources that fail are nil; a required source that fails
+// is an error.
+func waitSources(fetches []*sourceFetch) (map[string]interface{}, error) {
+	sources := make(map[string]interface{}, len(fetches))
+	var firstErr error
+	for _, f := range fetches {
+		var res sourceResult
+		select {
+		case res = <-f.done:
+		case <-f.ctx.Done():
+			res.err = f.ctx.Err()
+		}
+		f.cancel()
+
+		if res.err == nil {
+			sources[f.source.Name] = res.data
+			continue
+		}
+		err := fmt.Errorf("stencil: source %s: %v", f.source.Name, res.err)
+		if !f.source.Optional {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		log.Printf("[WARNING] %v", err)
+		sources[f.source.Name] = nil
+	}
+	return sources, firstErr
+}
+
+// cancelSources stops fetches that are no longer needed.
+func cancelSources(fetches []*sourceFetch) {
+	for _, f := range fetches {
+		f.cancel()
+	}
+}
@@ -0,0 +1,158 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"text/template"
+	"time"
+)
+
+func TestSources(t *testing.T) {
+	docs := map[string]string{
+		"/page.json":           `{"title": "Weather"}`,
+		"/location/44418.json": `{"city": "London"}`,
+	}
+
+	cfg := &Config{
+		PathScope:     "/page.json",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      template.Must(template.New("").Parse(`{{ .Doc.title }} in {{ .Sources.weather.city }}{{ if .Sources.alerts }}!{{ end }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		FetchDepth:    defaultFetchDepth,
+		Sources: []Source{
+			{Name: "weather", URL: "/location/{query.id}.json", Timeout: time.Second},
+			{Name: "alerts", URL: "/slow.json", Timeout: 10 * time.Millisecond, Optional: true},
+		},
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if r.URL.Path == "/slow.json" {
+			<-r.Context().Done()
+			return http.StatusGatewayTimeout, r.Context().Err()
+		}
+		doc, ok := docs[r.URL.Path]
+		if !ok {
+			return http.StatusNotFound, nil
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(doc))
+		return http.StatusOK, nil
+	})
+
+	get := func(target string) (*httptest.ResponseRecorder, int, error) {
+		req, err := http.NewRequest("GET", target, nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		rec := httptest.NewRecorder()
+		code, err := st.ServeHTTP(rec, req)
+		return rec, code, err
+	}
+
+	rec, _, err := get("/page.json?id=44418")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "Weather in London" {
+		t.Errorf("Expected source data in page, got %q", got)
+	}
+
+	// a required source that fails fails the render
+	if _, code, err := get("/page.json?id=0"); err == nil || code != http.StatusBadGateway {
+		t.Errorf("Expected failed source to fail the render, got %d, %v", code, err)
+	}
+}
+
+func TestExpandSource(t *testing.T) {
+	tests := []struct {
+		target   string
+		url      string
+		params   map[string]string
+		expected string
+		isError  bool
+	}{
+		{"/weather?id=44418&unit=c", "/api/{query.id}/?unit={query.unit}&host={host}", nil, "/api/44418/?unit=c&host=example.com", false},
+		{"/weather?id=44418", "/api/{id}/", map[string]string{"id": "2459115"}, "/api/2459115/", false},
+		{"/weather", "/api/{query.id}/", nil, "/api//", false},
+		// query parameters can't shadow Caddy placeholders
+		{"/weather?host=evil.com&path=/admin", "//{host}{path}", nil, "//example.com/weather", false},
+		// values can't leave their path segment or start a query
+		{"/weather?id=../../admin%3Fx", "/location/{query.id}/", nil, "/location/..%2F..%2Fadmin%3Fx/", false},
+		{"/weather?id=..", "/location/{query.id}/", nil, "", true},
+		{"/weather", "/location/{id}/", map[string]string{"id": "."}, "", true},
+		{"/weather?q=a%26b%3Dc", "/search?q={query.q}", nil, "/search?q=a%26b%3Dc", false},
+		// values don't go through the replacer
+		{"/weather?id=%7B%3EAuthorization%7D", "/location/{query.id}", nil, "/location/%7B%3EAuthorization%7D", false},
+		{"/weather?id=1", "/api/{?id}/{query.id}?x={query.id}", nil, "/api/1/1?x=1", false},
+	}
+
+	for i, test := range tests {
+		req, err := http.NewRequest("GET", test.target, nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		req.Host = "example.com"
+		req.Header.Set("Authorization", "Bearer secret")
+
+		got, err := expandSource(test.url, req, test.params)
+		if test.isError {
+			if err == nil {
+				t.Errorf("Test %d: expected error, got %q", i, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Test %d: unexpected error: %v", i, err)
+		}
+		if got != test.expected {
+			t.Errorf("Test %d: expected %q, got %q", i, test.expected, got)
+		}
+	}
+}
+
+func TestSourcesOnlyForStencils(t *testing.T) {
+	var fetched int32
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      GetDefaultTemplate(),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+		FetchDepth:    defaultFetchDepth,
+		Sources:       []Source{{Name: "weather", URL: "/weather.json", Timeout: time.Second}},
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		if r.URL.Path == "/weather.json" {
+			atomic.AddInt32(&fetched, 1)
+		}
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("body {}"))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/style.css", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	if _, err := st.ServeHTTP(httptest.NewRecorder(), req); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&fetched); n != 0 {
+		t.Errorf("Expected no sources to be fetched for an asset, got %d fetches", n)
+	}
+}
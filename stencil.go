@@ -36,15 +36,19 @@ package stencil
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"mime"
 	"net/http"
 	"os"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"text/template"
 	"time"
 
+	"github.com/jimjimovich/caddy-stencil/metadata"
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 )
 
@@ -62,6 +66,15 @@ type Stencil struct {
 	Configs []*Config
 
 	BufPool *sync.Pool
+
+	// Rendered pages, nil if no configuration enables caching
+	cache *renderCache
+
+	// Renders in progress, nil if no configuration enables coalescing
+	flights *flightGroup
+
+	// Last good pages, nil if no configuration enables the stale fallback
+	fallback *fallbackStore
 }
 
 // Config stores stencil middleware configurations.
@@ -77,6 +90,115 @@ type Config struct {
 
 	// a pair of template's name and its underlying file information
 	TemplateFiles map[string]*CachedFileInfo
+
+	// Front matter key holding the response settings of a document, empty
+	// if documents can't change the response
+	Namespace string
+
+	// How long rendered pages are cached, zero disables caching
+	CacheTTL time.Duration
+
+	// Maximum number of pages to keep in the cache
+	CacheSize int
+
+	// How long a stale page may be served while it is refreshed
+	StaleWhileRevalidate time.Duration
+
+	// How long a stale page may be served when upstream fails
+	StaleIfError time.Duration
+
+	// Whether concurrent identical requests share a single render
+	Coalesce bool
+
+	// Request headers that must match for requests to share a render
+	CoalesceHeaders []string
+
+	// How old the last good page may be to serve it when upstream fails,
+	// zero disables the fallback
+	FallbackStale time.Duration
+
+	// Directory to persist last good pages in, if any
+	FallbackDir string
+
+	// Maximum size of the upstream body in bytes, zero for no limit
+	MaxBody int64
+
+	// Maximum nesting depth of JSON input, zero for no limit
+	MaxDepth int
+
+	// Maximum number of values in JSON input, zero for no limit
+	MaxElements int
+
+	// Maximum number of lines in NDJSON input, zero for no limit
+	MaxLines int
+
+	// Status code to send when input exceeds a limit
+	LimitStatus int
+
+	// Maximum number of templates executing at once, zero for no limit
+	MaxRenders int
+
+	// Maximum number of renders waiting for a free slot
+	RenderQueue int
+
+	// How long a render may wait for a free slot
+	RenderWait time.Duration
+
+	// Maximum time a template may take to execute, zero for no limit
+	RenderTimeout time.Duration
+
+	// Maximum size of a rendered page in bytes, zero for no limit
+	MaxOutput int64
+
+	// Maximum number of fetches a render may make
+	FetchLimit int
+
+	// Maximum nesting of fetches through loopback requests
+	FetchDepth int
+
+	// Request headers passed on to fetches
+	FetchHeaders []string
+
+	// Data fetched for every render
+	Sources []Source
+
+	// Accept header sent to the upstream, empty to pass on the client's
+	UpstreamAccept string
+
+	// Request headers removed before passing requests upstream
+	UpstreamStrip []string
+
+	// Request headers set before passing requests upstream
+	UpstreamHeaders http.Header
+
+	// Whether complete HTML documents are split into title, meta tags,
+	// head assets and body
+	ParseHTML bool
+
+	// JSON Pointer to the part of the document data templates see as
+	// .Doc.data, empty for all of it
+	DataRoot string
+
+	// Parts of upstream HTML made available to templates
+	Extracts []Extract
+
+	// Character set of upstream documents, detected if empty
+	InputCharset string
+
+	// Request methods that are rendered, GET and HEAD if empty
+	Methods map[string]struct{}
+
+	// Whether pages are written to the client as they are rendered
+	Stream bool
+
+	// Path of the cache admin endpoint, empty if disabled
+	AdminPath string
+
+	// Shared secret that admin requests must send
+	AdminSecret string
+
+	// Render slots, nil if MaxRenders is zero
+	limiter *renderLimiter
 }
 
 type CachedFileInfo struct {
@@ -86,15 +208,110 @@ type CachedFileInfo struct {
 
 // ServeHTTP implements the http.Handler interface.
 func (st Stencil) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error) {
-	var cfg *Config
+	if cfg := st.adminConfig(r); cfg != nil {
+		return st.serveAdmin(w, r, cfg)
+	}
+
+	cfg := st.config(r)
+	if cfg == nil || !cfg.renders(r.Method) {
+		return st.Next.ServeHTTP(w, r)
+	}
+
+	// streamed pages can't be cached or shared
+	if cfg.Stream {
+		_, code, err := st.render(w, r, cfg, false)
+		return code, err
+	}
+
+	// serve from the cache if we can
+	var stale *cacheEntry
+	cacheable := st.cache != nil && cfg.CacheTTL > 0 && cacheableRequest(r)
+	if cacheable {
+		if e := st.cache.get(r, cfg); e != nil {
+			now := time.Now()
+			switch {
+			case e.fresh(now):
+				e.page.serve(w, r)
+				return 0, nil
+			case e.staleWhileRevalidate(now):
+				if st.cache.startRevalidation(e) {
+					go st.revalidate(backgroundRequest(r), cfg, e)
+				}
+				e.page.serve(w, r)
+				return 0, nil
+			case e.staleIfError(now):
+				stale = e
+			}
+		}
+	}
+
+	// hold on to upstream errors if we may have something else to serve
+	useFallback := st.fallback != nil && cfg.FallbackStale > 0 && cacheableRequest(r)
+	p, code, err := st.renderShared(w, r, cfg, stale != nil || useFallback)
+	if p == nil {
+		if err == nil && code < 500 {
+			return code, err
+		}
+		var fallback *page
+		if stale != nil {
+			fallback = stale.page
+		} else if useFallback {
+			fallback = st.fallback.get(r, cfg, time.Now())
+		}
+		if fallback != nil {
+			w.Header().Del("Retry-After")
+			w.Header().Set("Warning", staleWarning)
+			fallback.serve(w, r)
+			return 0, nil
+		}
+		return code, err
+	}
+
+	if cacheable {
+		st.cache.put(r, cfg, p)
+	}
+	if useFallback {
+		st.fallback.put(r, cfg, p)
+	}
+	p.serve(w, r)
+
+	return 0, nil
+}
+
+// config returns the configuration that applies to r, if any.
+func (st Stencil) config(r *http.Request) *Config {
 	for _, c := range st.Configs {
-		if httpserver.Path(r.URL.Path).Matches(c.PathScope) {
-			cfg = c
-			break
+		if _, ok := matchScope(c.PathScope, r.URL.Path); ok {
+			return c
 		}
 	}
-	if cfg == nil {
-		return st.Next.ServeHTTP(w, r)
+	return nil
+}
+
+// render passes the request up the chain and renders the response that
+// comes back. If the response is not a stencil document, it is written
+// to w as is and a nil page is returned with the upstream status and
+// error. If captureErrors is true, upstream 5xx responses are not
+// written to w so that the caller can serve something else instead.
+func (st Stencil) render(w http.ResponseWriter, r *http.Request, cfg *Config, captureErrors bool) (*page, int, error) {
+	// read the submitted form, leaving the body for upstream
+	form, err := readForm(r)
+	if err == errFormTooLarge {
+		return nil, http.StatusRequestEntityTooLarge, err
+	} else if err != nil {
+		return nil, http.StatusBadRequest, err
+	}
+
+	// sources are fetched on behalf of the request as the client sent it,
+	// but only once we know that the response will be rendered
+	params, _ := matchScope(cfg.PathScope, r.URL.Path)
+	var sourceReq *http.Request
+	if len(cfg.Sources) > 0 {
+		sourceReq = r.WithContext(r.Context())
+		sourceReq.Header = make(http.Header, len(r.Header))
+		for k, v := range r.Header {
+			sourceReq.Header[k] = v
+		}
 	}
 
 	originalMethod := r.Method
@@ -106,67 +323,261 @@ func (st Stencil) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, error)
 
 	fpath := r.URL.Path
 
+	// Conditional and range requests are answered from the rendered output,
+	// so keep upstream from answering them based on the raw content.
+	var condHeader http.Header
+	if _, ok := cfg.Extensions[path.Ext(fpath)]; ok || path.Ext(fpath) == "" {
+		condHeader = stripConditionalHeaders(r.Header)
+	}
+
+	// ask upstream for the content in the form we want it
+	upstreamHeader := rewriteUpstreamHeaders(r.Header, cfg)
+
+	// reset to original HTTP method and headers if we changed them
+	defer func() {
+		r.Method = originalMethod
+		restoreHeaders(r.Header, upstreamHeader)
+		for k, v := range condHeader {
+			r.Header[k] = v
+		}
+	}()
+
 	// get a buffer from the pool and make a response recorder
 	buf := st.BufPool.Get().(*bytes.Buffer)
 	buf.Reset()
-	defer st.BufPool.Put(buf)
+	defer func() {
+		// don't keep the memory of huge responses around
+		if buf.Cap() <= maxPooledBufferSize {
+			st.BufPool.Put(buf)
+		}
+	}()
 
 	// only buffer the response when we want to execute a stencil
+	var upstreamStatus int
+	var sources []*sourceFetch
+	defer func() { cancelSources(sources) }()
 	shouldBuf := func(status int, header http.Header) bool {
-		// see if this request matches a stencil extension
-		reqExt := path.Ext(fpath)
-		for ext := range cfg.Extensions {
-			// do not buffer if redirect or error
-			if status >= 300 {
-				return false
-			}
-			if reqExt == "" {
-				// request has no extension, so check response Content-Type
-				ct := mime.TypeByExtension(ext)
-				if ct != "" && strings.Contains(ct, header.Get("Content-Type")) {
-					return true
-				}
-			} else if reqExt == ext {
-				return true
-			}
+		upstreamStatus = status
+
+		// hold on to errors if the caller has something better to send
+		if captureErrors && status >= 500 {
+			return true
 		}
-		return false
+
+		if !isStencil(cfg, fpath, status, header) {
+			return false
+		}
+
+		// fetch the configured sources while upstream sends the content
+		if sourceReq != nil {
+			sources = fetchSources(st.Next, sourceReq, cfg, params)
+		}
+		return true
 	}
 
 	// prepare a buffer to hold the response, if applicable
 	rb := httpserver.NewResponseBuffer(buf, w, shouldBuf)
+	var next http.ResponseWriter = rb
+	var limit *bodyLimitWriter
+	if cfg.MaxBody > 0 {
+		limit = &bodyLimitWriter{ResponseBuffer: rb, max: cfg.MaxBody}
+		next = limit
+	}
 
 	// pass request up the chain to let another middleware provide us content
 	// this will most likely come from staticfiles or proxy
-	code, err := st.Next.ServeHTTP(rb, r)
+	code, err := st.Next.ServeHTTP(next, r)
+	if limit != nil && limit.exceeded {
+		return nil, cfg.LimitStatus, errBodyTooLarge
+	}
+	if captureErrors && upstreamStatus >= 500 {
+		return nil, upstreamStatus, err
+	}
 	if !rb.Buffered() || code >= 300 || err != nil {
-		return code, err
+		return nil, code, err
+	}
+
+	// parse what upstream meant to send, not its compressed form
+	body, err := decodeResponse(rb.Header(), rb.Buffer.Bytes(), cfg.MaxBody)
+	if err == errBodyTooLarge {
+		return nil, cfg.LimitStatus, err
+	} else if err != nil {
+		return nil, http.StatusBadGateway, err
+	}
+
+	// templates work with UTF-8
+	format := metadata.DataFormat(path.Ext(fpath), rb.Header().Get("Content-Type"))
+	if !metadata.IsBinary(format) {
+		body, err = toUTF8(body, rb.Header().Get("Content-Type"), cfg.InputCharset)
+		if err != nil {
+			return nil, http.StatusBadGateway, err
+		}
+	}
+
+	if err := checkInput(body, format, cfg); err != nil {
+		return nil, cfg.LimitStatus, err
 	}
 
-	// create an execution context
-	ctx := httpserver.NewContextWithHeader(w.Header())
+	// create an execution context with a copy of the buffered header
+	header := make(http.Header)
+	for k, v := range rb.Header() {
+		header[k] = v
+	}
+	ctx := httpserver.NewContextWithHeader(header)
 	ctx.Root = st.FileSys
 	ctx.Req = r
 	ctx.URL = r.URL
 
-	html, err := cfg.Stencil(title(fpath), rb.Buffer, ctx)
+	// don't hold a render slot while the sources are still loading
+	sourceData, err := waitSources(sources)
 	if err != nil {
-		return http.StatusInternalServerError, err
+		return nil, http.StatusBadGateway, err
 	}
 
-	// reset to original HTTP method if we changed it
-	if r.Method != originalMethod {
-		r.Method = originalMethod
+	// bound the number of templates executing at once
+	if cfg.limiter != nil {
+		if err := cfg.limiter.acquire(r.Context()); err != nil {
+			if err == errSaturated {
+				w.Header().Set("Retry-After", strconv.Itoa(cfg.limiter.retryAfter()))
+			}
+			return nil, http.StatusServiceUnavailable, err
+		}
+		defer cfg.limiter.release()
 	}
 
-	// copy the buffered header into the real ResponseWriter
-	rb.CopyHeader()
+	intent := newResponseIntent()
+	data := Data{
+		Context:  ctx,
+		Params:   params,
+		Form:     form,
+		Sources:  sourceData,
+		Upstream: newUpstream(upstreamStatus, rb.Header()),
+		intent:   intent,
+		fetcher:  newFetcher(st.Next, r, cfg),
+	}
 
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	lastModTime, _ := time.Parse(http.TimeFormat, w.Header().Get("Last-Modified"))
-	http.ServeContent(rb.StatusCodeWriter(w), r, fpath, lastModTime, bytes.NewReader(html))
+	// write the page to the client as it is rendered
+	if cfg.Stream {
+		sw := newStreamWriter(w, r, header, intent, upstreamStatus, originalMethod == http.MethodHead)
+		err := cfg.StencilTo(sw, title(fpath), bytes.NewBuffer(body), data)
+		if err != nil && !sw.wroteHeader {
+			return nil, renderErrorStatus(err, cfg), err
+		}
+		sw.close()
+		return nil, 0, err
+	}
 
-	return 0, nil
+	html, err := cfg.Stencil(title(fpath), bytes.NewBuffer(body), data)
+	if err != nil {
+		return nil, renderErrorStatus(err, cfg), err
+	}
+
+	return newPage(header, intent, html, upstreamStatus), 0, nil
+}
+
+// renderErrorStatus returns the status code to answer with when rendering
+// a document for cfg failed with err.
+func renderErrorStatus(err error, cfg *Config) int {
+	switch err {
+	case metadata.ErrInvalidDocument:
+		// the upstream sent something other than what it said it did
+		return http.StatusBadGateway
+	case metadata.ErrTooDeep, metadata.ErrTooManyElements:
+		// binary documents are only checked while they are parsed
+		return cfg.LimitStatus
+	}
+	return http.StatusInternalServerError
+}
+
+// isStencil reports whether the upstream response for fpath with the
+// status and header is a stencil document.
+func isStencil(cfg *Config, fpath string, status int, header http.Header) bool {
+	// do not buffer if redirect or error
+	if status >= 300 {
+		return false
+	}
+
+	// see if this request matches a stencil extension
+	reqExt := path.Ext(fpath)
+	for ext := range cfg.Extensions {
+		if reqExt == "" {
+			// request has no extension, so check response Content-Type
+			ct := mime.TypeByExtension(ext)
+			if ct != "" && strings.Contains(ct, header.Get("Content-Type")) {
+				return true
+			}
+		} else if reqExt == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// conditionalHeaders are the request headers that make a response depend
+// on the validators or ranges of the content.
+var conditionalHeaders = []string{
+	"If-Match",
+	"If-None-Match",
+	"If-Modified-Since",
+	"If-Unmodified-Since",
+	"If-Range",
+	"Range",
+}
+
+// stripConditionalHeaders removes the conditional headers from h and
+// returns them so they can be restored.
+func stripConditionalHeaders(h http.Header) http.Header {
+	removed := make(http.Header)
+	for _, k := range conditionalHeaders {
+		if v, ok := h[k]; ok {
+			removed[k] = v
+			delete(h, k)
+		}
+	}
+	return removed
+}
+
+// rewriteUpstreamHeaders changes h as cfg asks for requests to the
+// upstream. It returns the original values of the headers it changed, nil
+// for those that were not set, so that they can be restored.
+func rewriteUpstreamHeaders(h http.Header, cfg *Config) http.Header {
+	saved := make(http.Header)
+	save := func(k string) {
+		if _, ok := saved[k]; !ok {
+			saved[k] = h[k]
+		}
+	}
+
+	for _, k := range cfg.UpstreamStrip {
+		save(k)
+		delete(h, k)
+	}
+	if cfg.UpstreamAccept != "" {
+		save("Accept")
+		h.Set("Accept", cfg.UpstreamAccept)
+	}
+	for k, v := range cfg.UpstreamHeaders {
+		save(k)
+		h[k] = v
+	}
+	return saved
+}
+
+// restoreHeaders undoes the changes recorded by rewriteUpstreamHeaders.
+func restoreHeaders(h, saved http.Header) {
+	for k, v := range saved {
+		if v == nil {
+			delete(h, k)
+		} else {
+			h[k] = v
+		}
+	}
+}
+
+// etag returns a strong entity tag for the rendered output
+func etag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
 }
 
 // title gives a backup generated title for a page
@@ -126,6 +126,17 @@ func TestStencil(t *testing.T) {
 			"/index.html",
 			"/index.html",
 		},
+		{
+			"./testdata/json",
+			`stencil / {
+				ext .json
+				template ./testdata/json/template.html
+				stream
+			}
+			`,
+			"/44418.json",
+			"/44418_expected.html",
+		},
 	}
 
 	for _, test := range tests {
@@ -165,6 +176,100 @@ func TestStencil(t *testing.T) {
 
 }
 
+func TestStencilResponse(t *testing.T) {
+	tests := []struct {
+		getPath        string
+		expectedStatus int
+		expectedHeader map[string]string
+	}{
+		{"/found.json", http.StatusOK, map[string]string{}},
+		{"/empty.json", http.StatusNotFound, map[string]string{"X-Stencil": "empty"}},
+		{"/moved.json", http.StatusMovedPermanently, map[string]string{"Location": "/found.json"}},
+	}
+
+	for i, test := range tests {
+		c := caddy.NewTestController("http", `stencil / {
+			template ./testdata/response/template.html
+		}`)
+		if err := stencil.Setup(c); err != nil {
+			t.Fatalf("Something went wrong loading the controller: %v\n", err)
+		}
+
+		mids := httpserver.GetConfig(c).Middleware()
+		handler := mids[0](httpserver.EmptyNext).(stencil.Stencil)
+		handler.Next = staticfiles.FileServer{Root: http.Dir("./testdata/response")}
+
+		req, err := http.NewRequest("GET", test.getPath, nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		req = req.WithContext(context.WithValue(req.Context(), httpserver.OriginalURLCtxKey, *req.URL))
+
+		rec := httptest.NewRecorder()
+		if _, err := handler.ServeHTTP(rec, req); err != nil {
+			t.Fatal(err)
+		}
+
+		if rec.Code != test.expectedStatus {
+			t.Errorf("Test %d: expected status %d, got %d", i, test.expectedStatus, rec.Code)
+		}
+		for k, v := range test.expectedHeader {
+			if got := rec.Header().Get(k); got != v {
+				t.Errorf("Test %d: expected header %s to be %q, got %q", i, k, v, got)
+			}
+		}
+	}
+}
+
+func TestStencilConditional(t *testing.T) {
+	c := caddy.NewTestController("http", `stencil / {
+		template ./testdata/response/template.html
+	}`)
+	if err := stencil.Setup(c); err != nil {
+		t.Fatalf("Something went wrong loading the controller: %v\n", err)
+	}
+
+	mids := httpserver.GetConfig(c).Middleware()
+	handler := mids[0](httpserver.EmptyNext).(stencil.Stencil)
+	handler.Next = staticfiles.FileServer{Root: http.Dir("./testdata/response")}
+
+	get := func(header http.Header) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/found.json", nil)
+		if err != nil {
+			t.Fatalf("Could not create HTTP request: %v", err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		req = req.WithContext(context.WithValue(req.Context(), httpserver.OriginalURLCtxKey, *req.URL))
+
+		rec := httptest.NewRecorder()
+		if _, err := handler.ServeHTTP(rec, req); err != nil {
+			t.Fatal(err)
+		}
+		return rec
+	}
+
+	first := get(nil)
+	etag := first.Header().Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		t.Fatalf("Expected a strong ETag, got %q", etag)
+	}
+
+	second := get(http.Header{"If-None-Match": {etag}})
+	if second.Code != http.StatusNotModified {
+		t.Errorf("Expected status %d for matching ETag, got %d", http.StatusNotModified, second.Code)
+	}
+
+	third := get(http.Header{"If-None-Match": {`"stale"`}})
+	if third.Code != http.StatusOK {
+		t.Errorf("Expected status %d for stale ETag, got %d", http.StatusOK, third.Code)
+	}
+	if third.Body.String() != first.Body.String() {
+		t.Errorf("Expected body %q, got %q", first.Body.String(), third.Body.String())
+	}
+}
+
 func expected(filename string) []byte {
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
@@ -0,0 +1,118 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"bytes"
+	"net/http"
+)
+
+// headEnd is flushed to the client as soon as it is rendered, so that the
+// browser can start fetching assets while the body is still rendering.
+var headEnd = []byte("</head>")
+
+// streamWriter writes rendered output straight to the client. The header
+// is sent with the first write, so templates can still change the
+// response until they produce output.
+type streamWriter struct {
+	w              http.ResponseWriter
+	r              *http.Request
+	header         http.Header
+	intent         *responseIntent
+	upstreamStatus int
+	head           bool
+
+	wroteHeader bool
+	discard     bool
+	flushedHead bool
+}
+
+func newStreamWriter(w http.ResponseWriter, r *http.Request, header http.Header, intent *responseIntent, upstreamStatus int, head bool) *streamWriter {
+	return &streamWriter{
+		w:              w,
+		r:              r,
+		header:         header,
+		intent:         intent,
+		upstreamStatus: upstreamStatus,
+		head:           head,
+	}
+}
+
+// writeHeader sends the header to the client. The length and validators
+// of the upstream content don't apply to the rendered page, and ranges
+// can't be served while streaming.
+func (sw *streamWriter) writeHeader() {
+	sw.wroteHeader = true
+
+	h := sw.w.Header()
+	for k, v := range sw.header {
+		h[k] = v
+	}
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	h.Del("Content-Length")
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Del("Accept-Ranges")
+	sw.intent.applyHeader(h)
+
+	if sw.intent.redirect != "" {
+		http.Redirect(sw.w, sw.r, sw.intent.redirect, sw.intent.redirectStatus())
+		sw.discard = true
+		return
+	}
+
+	status := sw.intent.status
+	if status == 0 {
+		status = sw.upstreamStatus
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	sw.w.WriteHeader(status)
+	sw.discard = sw.head || !bodyAllowed(status)
+}
+
+func (sw *streamWriter) Write(b []byte) (int, error) {
+	if !sw.wroteHeader {
+		sw.writeHeader()
+	}
+	if sw.discard {
+		return len(b), nil
+	}
+
+	n, err := sw.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	if !sw.flushedHead && bytes.Contains(b, headEnd) {
+		sw.flushedHead = true
+		sw.flush()
+	}
+	return n, nil
+}
+
+// close finishes the response, sending the header if nothing was written.
+func (sw *streamWriter) close() {
+	if !sw.wroteHeader {
+		sw.writeHeader()
+	}
+	sw.flush()
+}
+
+func (sw *streamWriter) flush() {
+	if f, ok := sw.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -35,8 +35,11 @@
 package stencil
 
 import (
-	"bytes"
+	"context"
+	"io"
 	"io/ioutil"
+	"log"
+	"net/url"
 	"os"
 	"sync"
 	"text/template"
@@ -48,8 +51,15 @@ import (
 // Data represents a stencil document.
 type Data struct {
 	httpserver.Context
-	Doc   map[string]interface{}
-	Files []FileInfo
+	Doc      map[string]interface{}
+	Files    []FileInfo
+	Params   map[string]string
+	Form     url.Values
+	Sources  map[string]interface{}
+	Upstream *Upstream
+
+	intent  *responseIntent
+	fetcher *fetcher
 }
 
 // Include "overrides" the embedded httpserver.Context's Include()
@@ -63,17 +73,12 @@ func (d Data) Include(filename string, args ...interface{}) (string, error) {
 var templateUpdateMu sync.RWMutex
 
 // execTemplate executes a template given a requestPath, template, and metadata
-func execTemplate(c *Config, mdata metadata.Metadata, ctx httpserver.Context) ([]byte, error) {
-	mdData := Data{
-		Context: ctx,
-		Doc:     mdata.Variables,
-	}
-	templateName := mdata.Template
+// and writes the result to w
+func execTemplate(c *Config, mdata metadata.Metadata, mdData Data, w io.Writer) error {
+	mdData.Doc = mdata.Variables
+	templateName := expandParams(mdata.Template, mdData.Params)
 
 	updateTemplate := func() error {
-		templateUpdateMu.Lock()
-		defer templateUpdateMu.Unlock()
-
 		templateFile, ok := c.TemplateFiles[templateName]
 		if !ok {
 			return nil
@@ -84,32 +89,78 @@ func execTemplate(c *Config, mdata metadata.Metadata, ctx httpserver.Context) ([
 			return err
 		}
 
+		templateUpdateMu.RLock()
+		changed := fileChanged(currentFileInfo, templateFile.Fi)
+		templateUpdateMu.RUnlock()
+		if !changed {
+			return nil
+		}
+
+		templateUpdateMu.Lock()
+		defer templateUpdateMu.Unlock()
+
+		// another render may have updated it in the meantime
 		if !fileChanged(currentFileInfo, templateFile.Fi) {
 			return nil
 		}
 
-		// update template due to file changes
-		err = SetTemplate(c.Template, templateName, templateFile.Path)
+		// update a copy of the template due to file changes, so renders
+		// in progress keep executing the one they started with
+		t, err := c.Template.Clone()
+		if err != nil {
+			return err
+		}
+		err = SetTemplate(t, templateName, templateFile.Path)
 		if err != nil {
 			return err
 		}
 
+		c.Template = t
 		templateFile.Fi = currentFileInfo
 		return nil
 	}
 
 	if err := updateTemplate(); err != nil {
-		return nil, err
+		return err
 	}
 
-	b := new(bytes.Buffer)
+	// Templates are replaced rather than changed, so t can be executed
+	// without the lock while other renders update the template. Holding
+	// it here would stall updates behind slow clients and fetches.
 	templateUpdateMu.RLock()
-	defer templateUpdateMu.RUnlock()
-	if err := c.Template.ExecuteTemplate(b, templateName, mdData); err != nil {
-		return nil, err
+	if mdData.intent != nil {
+		mdData.intent.template = templateName
+		if templateFile, ok := c.TemplateFiles[templateName]; ok && templateFile.Fi != nil {
+			mdData.intent.modTime = templateFile.Fi.ModTime()
+		}
 	}
+	t := c.Template
+	templateUpdateMu.RUnlock()
 
-	return b.Bytes(), nil
+	// stop renders that run too long or produce too much output
+	ctx := context.Background()
+	if mdData.Req != nil {
+		ctx = mdData.Req.Context()
+	}
+	if c.RenderTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.RenderTimeout)
+		defer cancel()
+	}
+	w = &renderGuardWriter{w: w, ctx: ctx, max: c.MaxOutput}
+	if mdData.fetcher != nil {
+		mdData.fetcher.ctx = ctx
+	}
+
+	err := t.ExecuteTemplate(w, templateName, mdData)
+	if err == errRenderTimeout || err == errOutputTooLarge {
+		var path string
+		if mdData.URL != nil {
+			path = mdData.URL.Path
+		}
+		log.Printf("[ERROR] stencil: aborted render of %s with template %q: %v", path, templateName, err)
+	}
+	return err
 }
 
 func fileChanged(new, old os.FileInfo) bool {
@@ -0,0 +1,145 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Upstream is the response of the handler that provided the document,
+// as seen by templates.
+type Upstream struct {
+	Status int
+	Header http.Header
+
+	// Links of the Link header by relation type, for example "next"
+	Links map[string]Link
+}
+
+// Link is a web link from a Link header (RFC 8288).
+type Link struct {
+	URL    string
+	Rel    string
+	Params map[string]string
+}
+
+func newUpstream(status int, header http.Header) *Upstream {
+	if status == 0 {
+		status = http.StatusOK
+	}
+	h := make(http.Header, len(header))
+	for k, v := range header {
+		h[k] = append([]string(nil), v...)
+	}
+
+	links := make(map[string]Link)
+	for _, l := range parseLinks(header["Link"]) {
+		for _, rel := range strings.Fields(strings.ToLower(l.Rel)) {
+			// the first link of a relation type wins
+			if _, ok := links[rel]; !ok {
+				links[rel] = l
+			}
+		}
+	}
+
+	return &Upstream{Status: status, Header: h, Links: links}
+}
+
+// parseLinks parses the values of Link headers. Malformed links are
+// skipped.
+func parseLinks(values []string) []Link {
+	var links []Link
+	for _, v := range values {
+		for {
+			v = strings.TrimLeft(v, " \t,")
+			if v == "" || v[0] != '<' {
+				break
+			}
+			end := strings.IndexByte(v, '>')
+			if end < 0 {
+				break
+			}
+			l := Link{URL: strings.TrimSpace(v[1:end]), Params: make(map[string]string)}
+			v = v[end+1:]
+
+			// parameters, up to the next link
+			for {
+				v = strings.TrimLeft(v, " \t")
+				if v == "" || v[0] != ';' {
+					break
+				}
+				var name, value string
+				name, value, v = parseLinkParam(v[1:])
+				if name == "" {
+					continue
+				}
+				if _, ok := l.Params[name]; !ok {
+					l.Params[name] = value
+				}
+			}
+			l.Rel = l.Params["rel"]
+			links = append(links, l)
+
+			// skip whatever is left of a malformed link
+			if v != "" && v[0] != ',' {
+				i := strings.IndexByte(v, ',')
+				if i < 0 {
+					break
+				}
+				v = v[i:]
+			}
+		}
+	}
+	return links
+}
+
+// parseLinkParam parses a link parameter at the start of s and returns
+// its lowercased name, its value and the rest of s.
+func parseLinkParam(s string) (name, value, rest string) {
+	s = strings.TrimLeft(s, " \t")
+	i := strings.IndexAny(s, "=;,")
+	if i < 0 {
+		return strings.ToLower(strings.TrimSpace(s)), "", ""
+	}
+	name = strings.ToLower(strings.TrimSpace(s[:i]))
+	if s[i] != '=' {
+		return name, "", s[i:]
+	}
+
+	s = strings.TrimLeft(s[i+1:], " \t")
+	if strings.HasPrefix(s, `"`) {
+		var b []byte
+		for j := 1; j < len(s); j++ {
+			switch s[j] {
+			case '\\':
+				if j+1 < len(s) {
+					j++
+					b = append(b, s[j])
+				}
+			case '"':
+				return name, string(b), s[j+1:]
+			default:
+				b = append(b, s[j])
+			}
+		}
+		return name, string(b), ""
+	}
+
+	if j := strings.IndexAny(s, ";,"); j >= 0 {
+		return name, strings.TrimSpace(s[:j]), s[j:]
+	}
+	return name, strings.TrimSpace(s), ""
+}
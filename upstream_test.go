@@ -0,0 +1,122 @@
+// Copyright 2018 Jim Mendenhall
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package stencil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestParseLinks(t *testing.T) {
+	links := parseLinks([]string{
+		`<https://api.example.com/items?page=3>; rel="next"; title="Next, please", <https://api.example.com/items?page=1>; rel=prev`,
+		`<broken; rel=ignored`,
+		`</items?page=9>;REL="last start"`,
+	})
+	expected := []Link{
+		{URL: "https://api.example.com/items?page=3", Rel: "next", Params: map[string]string{"rel": "next", "title": "Next, please"}},
+		{URL: "https://api.example.com/items?page=1", Rel: "prev", Params: map[string]string{"rel": "prev"}},
+		{URL: "/items?page=9", Rel: "last start", Params: map[string]string{"rel": "last start"}},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected links %+v, got %+v", expected, links)
+	}
+
+	up := newUpstream(0, http.Header{"Link": {`</a>; rel=next, </b>; rel="next prev"`}})
+	if up.Status != http.StatusOK {
+		t.Errorf("Expected status 200, got %d", up.Status)
+	}
+	if up.Links["next"].URL != "/a" || up.Links["prev"].URL != "/b" {
+		t.Errorf("Expected first link of each relation, got %+v", up.Links)
+	}
+}
+
+func TestUpstream(t *testing.T) {
+	cfg := &Config{
+		PathScope:     "/",
+		Extensions:    map[string]struct{}{".json": {}},
+		Template:      template.Must(template.New("").Parse(`{{ .Upstream.Status }} {{ .Upstream.Header.Get "X-Total-Count" }} {{ .Upstream.Links.next.URL }}`)),
+		TemplateFiles: make(map[string]*CachedFileInfo),
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Total-Count", "42")
+		w.Header().Set("Link", `</items.json?page=2>; rel="next"`)
+		w.Write([]byte(`[]`))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/items.json", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	rec := httptest.NewRecorder()
+	if _, err := st.ServeHTTP(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(rec.Body.String()), "200 42 /items.json?page=2"; got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+func TestRewriteUpstreamHeaders(t *testing.T) {
+	var got http.Header
+	cfg := &Config{
+		PathScope:       "/",
+		Extensions:      map[string]struct{}{".json": {}},
+		Template:        template.Must(template.New("").Parse(`{{ .Doc.title }}`)),
+		TemplateFiles:   make(map[string]*CachedFileInfo),
+		UpstreamAccept:  "application/json",
+		UpstreamStrip:   []string{"Cookie"},
+		UpstreamHeaders: http.Header{"X-Api-Key": {"secret"}},
+	}
+	st := newTestStencil(cfg, func(w http.ResponseWriter, r *http.Request) (int, error) {
+		got = make(http.Header)
+		for k, v := range r.Header {
+			got[k] = v
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title": "Hello"}`))
+		return http.StatusOK, nil
+	})
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		t.Fatalf("Could not create HTTP request: %v", err)
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("Cookie", "session=1")
+	original := http.Header{"Accept": {"text/html"}, "Cookie": {"session=1"}}
+
+	rec := httptest.NewRecorder()
+	if _, err := st.ServeHTTP(rec, req); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(rec.Body.String()); got != "Hello" {
+		t.Errorf("Expected page to be rendered, got %q", got)
+	}
+
+	expected := http.Header{"Accept": {"application/json"}, "X-Api-Key": {"secret"}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected upstream request headers %v, got %v", expected, got)
+	}
+	if !reflect.DeepEqual(req.Header, original) {
+		t.Errorf("Expected request headers to be restored to %v, got %v", original, req.Header)
+	}
+}